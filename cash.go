@@ -0,0 +1,274 @@
+package main
+
+import (
+    "strconv"
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//    CashRequest - A deposit or withdrawal the account holder has asked for against a bank transfer. Nothing moves until
+//                  the exchange confirms it, except that a pending withdrawal holds back the amount and its fee as
+//                  Reserved. The bank reference identifies the request so asking twice returns the same request
+//==============================================================================================================================
+type CashRequest struct {
+    ID              string      `json:"requestID"`
+    Type            string      `json:"type"`
+    AccountID       string      `json:"accountID"`
+    Amount          float64     `json:"amount"`
+    Fee             float64     `json:"fee"`
+    Reserved        float64     `json:"reserved"`
+    BankReference   string      `json:"bankReference"`
+    Status          int         `json:"status"`
+    Requested       int64       `json:"requested"`
+    Resolved        int64       `json:"resolved,omitempty"`
+    ResolvedBy      string      `json:"resolvedBy,omitempty"`
+    Reason          string      `json:"reason,omitempty"`
+    Version         int         `json:"version"`
+}
+
+//==============================================================================================================================
+//     Query Logic Methods
+//==============================================================================================================================
+//     getCashRequest
+//==============================================================================================================================
+func (t *SimpleChaincode) getCashRequest(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    request, err := getCashRequest(stub, args.String("requestID"))
+    if checkErrors(err){return nil, err}
+
+    return request.marshal()
+}
+
+//==============================================================================================================================
+//     getCashRequests - An account's requests, optionally only those with the given status
+//==============================================================================================================================
+func (t *SimpleChaincode) getCashRequests(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    requests, err := getIndexedCashRequests(stub, "account", args.String("accountID"))
+    if checkErrors(err){return nil, err}
+
+    filtered := []CashRequest{}
+    for i := 0; i < len(requests); i++ {
+        if args.Has("status") && requests[i].Status != args.Int("status") {continue}
+        filtered = append(filtered, requests[i])
+    }
+    return marshalCashRequests(filtered)
+}
+
+//==============================================================================================================================
+//     getPendingCashRequests - Every request waiting on the exchange. The registry makes sure only the exchange can run it
+//==============================================================================================================================
+func (t *SimpleChaincode) getPendingCashRequests(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    requests, err := getIndexedCashRequests(stub, "status", strconv.Itoa(CASH_REQUEST_PENDING))
+    if checkErrors(err){return nil, err}
+
+    return marshalCashRequests(requests)
+}
+
+//==============================================================================================================================
+//     Invoke Logic Methods
+//==============================================================================================================================
+//     requestDeposit - Ask for cash received by the bank to be credited to the account
+//==============================================================================================================================
+func (t *SimpleChaincode) requestDeposit(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    request := CashRequest{Type: LEDGER_DEPOSIT, AccountID: args.String("accountID"), Amount: args.Float("amount"), BankReference: args.String("bankReference")}
+    if request.Amount <= 0 {return nil, newError(ERR_INVALID_ARGUMENT, "Deposit value must be positive")}
+
+    existing, found, err := request.findExisting(stub)
+    if checkErrors(err){return nil, err}
+    if found {return existing.marshal()}
+
+    account, err := getAccount(stub, request.AccountID)
+    if checkErrors(err){return nil, err}
+    err = checkNotProperty(stub, account)
+    if checkErrors(err){return nil, err}
+
+    err = request.create(stub)
+    if checkErrors(err){return nil, err}
+
+    log.info("Requested deposit", "requestID", request.ID, "accountID", request.AccountID, "amount", request.Amount)
+    return request.marshal()
+}
+
+//==============================================================================================================================
+//     requestWithdrawal - Ask for cash to be paid out to the bank. The amount and fee are reserved until the exchange
+//                         confirms or rejects the request
+//==============================================================================================================================
+func (t *SimpleChaincode) requestWithdrawal(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    request := CashRequest{Type: LEDGER_WITHDRAWAL, AccountID: args.String("accountID"), Amount: args.Float("amount"), BankReference: args.String("bankReference")}
+    if request.Amount <= 0 {return nil, newError(ERR_INVALID_ARGUMENT, "Withdrawal value must be positive")}
+
+    existing, found, err := request.findExisting(stub)
+    if checkErrors(err){return nil, err}
+    if found {return existing.marshal()}
+
+    account, err := getAccount(stub, request.AccountID)
+    if checkErrors(err){return nil, err}
+    err = checkNotProperty(stub, account)
+    if checkErrors(err){return nil, err}
+    if config.Limits.MaxWithdrawal > 0 && request.Amount > config.Limits.MaxWithdrawal {return nil, newError(ERR_INVALID_ARGUMENT, "Withdrawal exceeds the maximum value")}
+    err = checkWithdrawalLimit(stub, account, request.Amount)
+    if checkErrors(err){return nil, err}
+
+    schedule := config.Fees
+    request.Fee = schedule.flatFee(schedule.WithdrawalFee, account.Role)
+    request.Reserved = request.Amount + request.Fee
+    if account.Cash < request.Reserved {return nil, newError(ERR_INSUFFICIENT_FUNDS, "Not enough cash to withdraw")}
+
+    account.Cash -= request.Reserved
+    err = account.save(stub)
+    if checkErrors(err){return nil, err}
+
+    err = request.create(stub)
+    if checkErrors(err){return nil, err}
+
+    log.info("Requested withdrawal", "requestID", request.ID, "accountID", request.AccountID, "amount", request.Amount, "fee", request.Fee)
+    return request.marshal()
+}
+
+//==============================================================================================================================
+//     confirmCashRequest - The bank transfer has settled. Credit a deposit, or post a withdrawal whose cash is already
+//                          reserved. The registry makes sure only the exchange can do this
+//==============================================================================================================================
+func (t *SimpleChaincode) confirmCashRequest(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    request, err := getPendingCashRequest(stub, args.String("requestID"))
+    if checkErrors(err){return nil, err}
+
+    account, err := getAccount(stub, request.AccountID)
+    if checkErrors(err){return nil, err}
+
+    if request.Type == LEDGER_DEPOSIT {
+        account.Cash += request.Amount
+        err = account.save(stub)
+        if checkErrors(err){return nil, err}
+        err = postLedgerEntry(stub, account, LEDGER_DEPOSIT, request.Amount, 0, request.ID)
+        if checkErrors(err){return nil, err}
+    } else {
+        err = postLedgerEntry(stub, account, LEDGER_WITHDRAWAL, -request.Amount, request.Fee, request.ID)
+        if checkErrors(err){return nil, err}
+        err = collectFee(stub, config.Fees, request.Fee, account.ID, request.ID)
+        if checkErrors(err){return nil, err}
+    }
+
+    err = request.resolve(stub, CASH_REQUEST_CONFIRMED, args.String("exchangeID"), "")
+    if checkErrors(err){return nil, err}
+
+    log.info("Confirmed cash request", "requestID", request.ID, "type", request.Type, "accountID", account.ID, "amount", request.Amount)
+    return request.marshal()
+}
+
+//==============================================================================================================================
+//     rejectCashRequest - The bank transfer failed. A withdrawal's reserved cash goes back to the account. The registry
+//                         makes sure only the exchange can do this
+//==============================================================================================================================
+func (t *SimpleChaincode) rejectCashRequest(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    request, err := getPendingCashRequest(stub, args.String("requestID"))
+    if checkErrors(err){return nil, err}
+
+    if request.Reserved > 0 {
+        account, err := getAccount(stub, request.AccountID)
+        if checkErrors(err){return nil, err}
+
+        account.Cash += request.Reserved
+        err = account.save(stub)
+        if checkErrors(err){return nil, err}
+    }
+
+    err = request.resolve(stub, CASH_REQUEST_REJECTED, args.String("exchangeID"), args.String("reason"))
+    if checkErrors(err){return nil, err}
+
+    log.info("Rejected cash request", "requestID", request.ID, "type", request.Type, "accountID", request.AccountID, "reason", request.Reason)
+    return request.marshal()
+}
+
+//==============================================================================================================================
+//     CRUD Subroutines
+//==============================================================================================================================
+func getCashRequest(stub *shim.ChaincodeStub, id string) (CashRequest, error) {
+    var object CashRequest
+    err := cashRequests.get(stub, id, &object)
+    return object, err
+}
+
+func getPendingCashRequest(stub *shim.ChaincodeStub, id string) (CashRequest, error) {
+    object, err := getCashRequest(stub, id)
+    if checkErrors(err){return object, err}
+    if object.Status != CASH_REQUEST_PENDING {return object, newError(ERR_STATE_VIOLATION, "Cash request " + id + " has already been resolved")}
+    return object, nil
+}
+
+func getIndexedCashRequests(stub *shim.ChaincodeStub, index string, value string) ([]CashRequest, error) {
+    objects := []CashRequest{}
+
+    ids, err := cashRequests.list(stub, index, value)
+    if checkErrors(err){return nil, err}
+
+    for i := 0; i < len(ids); i++ {
+        object, err := getCashRequest(stub, ids[i])
+        if checkErrors(err){return nil, err}
+        objects = append(objects, object)
+    }
+    return objects, nil
+}
+
+//findExisting - the request already made with this bank reference. Reusing the reference for a different request
+//               is a conflict
+func (object *CashRequest) findExisting(stub *shim.ChaincodeStub) (CashRequest, bool, error) {
+    if object.BankReference == "" {return CashRequest{}, false, newError(ERR_INVALID_ARGUMENT, "A bank reference is required")}
+
+    existing, err := getCashRequest(stub, object.requestID())
+    if isNotFound(err) {return existing, false, nil}
+    if checkErrors(err){return existing, false, err}
+
+    if existing.AccountID != object.AccountID || existing.Amount != object.Amount {
+        return existing, false, newError(ERR_CONFLICT, "Bank reference " + object.BankReference + " has already been used")
+    }
+    return existing, true, nil
+}
+
+func (object *CashRequest) requestID() string {
+    return getMd5Hash(object.Type + object.BankReference)
+}
+
+func (object *CashRequest) create(stub *shim.ChaincodeStub) error {
+    var err error
+    object.ID = object.requestID()
+    object.Status = CASH_REQUEST_PENDING
+    object.Requested, err = getTxTime(stub)
+    if checkErrors(err){return err}
+
+    return cashRequests.create(stub, object)
+}
+
+func (object *CashRequest) resolve(stub *shim.ChaincodeStub, status int, resolvedBy string, reason string) error {
+    var err error
+    object.Status = status
+    object.ResolvedBy = resolvedBy
+    object.Reason = reason
+    object.Resolved, err = getTxTime(stub)
+    if checkErrors(err){return err}
+
+    return cashRequests.save(stub, object)
+}
+
+func (object *CashRequest) getID() string {return object.ID}
+func (object *CashRequest) getVersion() int {return object.Version}
+func (object *CashRequest) setVersion(version int) {object.Version = version}
+
+func (object *CashRequest) indexes() map[string][]string {
+    return map[string][]string{"account": {indexValue(object.AccountID)}, "status": {indexValue(strconv.Itoa(object.Status))}}
+}
+
+//==============================================================================================================================
+//     Parsing Subroutines
+//==============================================================================================================================
+func (object *CashRequest) marshal() ([]byte, error) {
+    bytes, err := json.Marshal(object)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling cash request", err)}
+    return bytes, nil
+}
+
+func marshalCashRequests(objects []CashRequest) ([]byte, error) {
+    bytes, err := json.Marshal(objects)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling cash request array", err)}
+    return bytes, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+    "strconv"
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//    Configuration - Persisted under CONFIG_KEY and loaded into config at the start of every call, so it survives
+//                    chaincode restarts. Only an admin can change it
+//==============================================================================================================================
+type Configuration struct {
+    LogLevel        int             `json:"logLevel"`
+    Fees            FeeSchedule     `json:"fees"`
+    TradingHours    TradingHours    `json:"tradingHours"`
+    Limits          Limits          `json:"limits"`
+    TickSize        float64         `json:"tickSize"`
+    SelfTradePrevention string      `json:"selfTradePrevention"`
+    RoleLimits      map[string]AccountLimits `json:"roleLimits"`
+    PriceBand       PriceBand       `json:"priceBand"`
+    RequestRetention int64          `json:"requestRetention"`
+}
+
+//==============================================================================================================================
+//    TradingHours - Seconds past midnight UTC. When Open and Close are equal the market never closes
+//==============================================================================================================================
+type TradingHours struct {
+    Open            int         `json:"open"`
+    Close           int         `json:"close"`
+}
+
+//==============================================================================================================================
+//    Limits - Zero means unlimited. These apply to every trade and withdrawal, RoleLimits are per account and keyed by role
+//==============================================================================================================================
+type Limits struct {
+    MaxTradeUnits   int         `json:"maxTradeUnits"`
+    MaxTradeValue   float64     `json:"maxTradeValue"`
+    MaxWithdrawal   float64     `json:"maxWithdrawal"`
+}
+
+//==============================================================================================================================
+//     Query Logic Methods
+//==============================================================================================================================
+//     getConfig
+//==============================================================================================================================
+func (t *SimpleChaincode) getConfig(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    return config.marshal()
+}
+
+//==============================================================================================================================
+//     Invoke Logic Methods
+//==============================================================================================================================
+//     setConfig - Replace the configuration. The registry makes sure only an admin can do this
+//==============================================================================================================================
+func (t *SimpleChaincode) setConfig(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    configuration := *args.Body("config").(*Configuration)
+
+    err := configuration.validate()
+    if checkErrors(err){return nil, err}
+
+    if configuration.Fees.FeeAccount != "" {
+        _, err = checkAccountRole(stub, configuration.Fees.FeeAccount, ROLE_EXCHANGE)
+        if checkErrors(err){return nil, wrapError(ERR_INVALID_ARGUMENT, "Fee account must be an active exchange account", err)}
+    }
+
+    err = configuration.save(stub)
+    if checkErrors(err){return nil, err}
+    config = configuration
+
+    log.info("Updated configuration")
+    return nil, nil
+}
+
+//==============================================================================================================================
+//     CRUD Subroutines
+//==============================================================================================================================
+//     getConfiguration - A ledger that has never been configured gets the defaults
+//==============================================================================================================================
+func getConfiguration(stub *shim.ChaincodeStub) (Configuration, error) {
+    object := defaultConfiguration()
+    bytes, err := stub.GetState(CONFIG_KEY)
+    if checkErrors(err){return object, wrapError(ERR_INTERNAL, "Couldn't retrieve configuration", err)}
+    if bytes == nil {return object, nil}
+
+    return unmarshalConfiguration(bytes)
+}
+
+func defaultConfiguration() Configuration {
+    var object Configuration
+    object.LogLevel = LOG_INFO
+    object.SelfTradePrevention = STP_CANCEL_NEWEST
+    object.RequestRetention = 7 * 86400
+    return object
+}
+
+func (object *Configuration) save(stub *shim.ChaincodeStub) error {
+    bytes, err := object.marshal()
+    if checkErrors(err){return err}
+
+    err = stub.PutState(CONFIG_KEY, bytes)
+    if checkErrors(err){return wrapError(ERR_INTERNAL, "Couldn't save configuration", err)}
+
+    return nil
+}
+
+func (object *Configuration) validate() error {
+    if object.LogLevel < LOG_DEBUG || object.LogLevel > LOG_ERROR {return newError(ERR_INVALID_ARGUMENT, "Invalid log level")}
+
+    err := object.Fees.validate()
+    if checkErrors(err){return err}
+
+    hours := object.TradingHours
+    if hours.Open < 0 || hours.Open >= 86400 || hours.Close < 0 || hours.Close >= 86400 {return newError(ERR_INVALID_ARGUMENT, "Trading hours must be between 0 and 86399 seconds past midnight")}
+
+    if object.Limits.MaxTradeUnits < 0 || object.Limits.MaxTradeValue < 0 || object.Limits.MaxWithdrawal < 0 {return newError(ERR_INVALID_ARGUMENT, "Limits can't be negative")}
+    if object.TickSize < 0 {return newError(ERR_INVALID_ARGUMENT, "Tick size can't be negative")}
+
+    for role, limits := range object.RoleLimits {
+        value, err := strconv.Atoi(role)
+        if checkErrors(err) || value < ROLE_MARKET_MAKER || value > ROLE_ADMIN {return newError(ERR_INVALID_ARGUMENT, "Invalid role " + role + " in limits")}
+        err = limits.validate()
+        if checkErrors(err){return err}
+    }
+    if !validSelfTradePrevention(object.SelfTradePrevention) {return newError(ERR_INVALID_ARGUMENT, "Invalid self trade prevention " + object.SelfTradePrevention)}
+
+    err = object.PriceBand.validate()
+    if checkErrors(err){return err}
+    if object.RequestRetention < 0 {return newError(ERR_INVALID_ARGUMENT, "Request retention can't be negative")}
+
+    return nil
+}
+
+//==============================================================================================================================
+//     Configuration Checks
+//==============================================================================================================================
+//     isOpen - Whether the market is open at the given time. Hours that wrap past midnight are allowed
+//==============================================================================================================================
+func (object *TradingHours) isOpen(timestamp int64) bool {
+    if object.Open == object.Close {return true}
+
+    seconds := int(timestamp % 86400)
+    if object.Open < object.Close {return seconds >= object.Open && seconds < object.Close}
+    return seconds >= object.Open || seconds < object.Close
+}
+
+//==============================================================================================================================
+//     checkTrade - Apply the configured trading hours and limits to a new trade. The tick size is applied with the
+//                  property's trading rules, whose reference price values a market trade
+//==============================================================================================================================
+func (object *Configuration) checkTrade(trade Trade, property Property) error {
+    if !object.TradingHours.isOpen(trade.Created) {return newError(ERR_STATE_VIOLATION, "The market is closed")}
+
+    limits := object.Limits
+    if limits.MaxTradeUnits > 0 && trade.Units > limits.MaxTradeUnits {return newError(ERR_INVALID_ARGUMENT, "Trade exceeds the maximum number of units")}
+    if limits.MaxTradeValue > 0 && trade.valuePrice(property) * float64(trade.Units) > limits.MaxTradeValue {return newError(ERR_INVALID_ARGUMENT, "Trade exceeds the maximum value")}
+
+    return nil
+}
+
+//==============================================================================================================================
+//     Parsing Subroutines
+//==============================================================================================================================
+func unmarshalConfiguration(bytes []byte) (Configuration, error) {
+    object := defaultConfiguration()
+    err := json.Unmarshal(bytes, &object)
+    if checkErrors(err){return object, wrapError(ERR_INTERNAL, "Error unmarshalling configuration", err)}
+    return object, nil
+}
+
+func (object *Configuration) marshal() ([]byte, error) {
+    bytes, err := json.Marshal(object)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling configuration", err)}
+    return bytes, nil
+}
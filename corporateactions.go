@@ -0,0 +1,534 @@
+package main
+
+import (
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//    Payout - What one holder received for their units in a corporate action
+//==============================================================================================================================
+type Payout struct {
+    AccountID       string      `json:"accountID"`
+    Units           int         `json:"units"`
+    Amount          float64     `json:"amount"`
+}
+
+//==============================================================================================================================
+//    ReclaimStatement - What reclaimProperty returns. Total is what the funder paid, which excludes its own units
+//==============================================================================================================================
+type ReclaimStatement struct {
+    PropertyID      string      `json:"propertyID"`
+    FunderID        string      `json:"funderID"`
+    PricePerUnit    float64     `json:"pricePerUnit"`
+    Payouts         []Payout    `json:"payouts"`
+    Total           float64     `json:"total"`
+    Timestamp       int64       `json:"timestamp"`
+}
+
+//==============================================================================================================================
+//    SaleStatement - The final statement for a property whose house has been sold, stored under the property ID. Each
+//                    holder gets PerUnit for each unit rounded down to the cent, and the rounding Residual stays with
+//                    the manager
+//==============================================================================================================================
+type SaleStatement struct {
+    PropertyID      string      `json:"propertyID"`
+    ManagerID       string      `json:"managerID"`
+    GrossProceeds   float64     `json:"grossProceeds"`
+    Costs           float64     `json:"costs"`
+    NetProceeds     float64     `json:"netProceeds"`
+    Units           int         `json:"units"`
+    PerUnit         float64     `json:"perUnit"`
+    Payouts         []Payout    `json:"payouts"`
+    Distributed     float64     `json:"distributed"`
+    Residual        float64     `json:"residual"`
+    Timestamp       int64       `json:"timestamp"`
+    Version         int         `json:"version"`
+}
+
+//==============================================================================================================================
+//    SplitStatement - What splitUnits returns. Each payout is a holder's units after the split and the cash paid in lieu
+//                     of the fraction of a unit they lost
+//==============================================================================================================================
+type SplitStatement struct {
+    PropertyID      string      `json:"propertyID"`
+    FunderID        string      `json:"funderID"`
+    RatioNum        int         `json:"ratioNum"`
+    RatioDen        int         `json:"ratioDen"`
+    Units           int         `json:"units"`
+    ReferencePrice  float64     `json:"referencePrice"`
+    Payouts         []Payout    `json:"payouts"`
+    CashInLieu      float64     `json:"cashInLieu"`
+    Timestamp       int64       `json:"timestamp"`
+}
+
+//==============================================================================================================================
+//    IssueResult - What issueUnits returns, with the rights offered to existing holders
+//==============================================================================================================================
+type IssueResult struct {
+    PropertyID      string      `json:"propertyID"`
+    Units           int         `json:"units"`
+    Price           float64     `json:"price"`
+    Rights          []Offer     `json:"rights"`
+}
+
+//==============================================================================================================================
+//     Query Logic Methods
+//==============================================================================================================================
+//     getSaleStatement
+//==============================================================================================================================
+func (t *SimpleChaincode) getSaleStatement(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    var statement SaleStatement
+    err := saleStatements.get(stub, args.String("propertyID"), &statement)
+    if checkErrors(err){return nil, err}
+
+    return statement.marshal()
+}
+
+//==============================================================================================================================
+//     getOffers - The property's offers, including rights offers reserved for a holder
+//==============================================================================================================================
+func (t *SimpleChaincode) getOffers(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    ids, err := offers.list(stub, "property", args.String("propertyID"))
+    if checkErrors(err){return nil, err}
+
+    objects := []Offer{}
+    for i := 0; i < len(ids); i++ {
+        offer, err := getOffer(stub, ids[i])
+        if checkErrors(err){return nil, err}
+        objects = append(objects, offer)
+    }
+
+    bytes, err := json.Marshal(objects)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling offers", err)}
+    return bytes, nil
+}
+
+//==============================================================================================================================
+//     Invoke Logic Methods
+//==============================================================================================================================
+//     reclaimProperty - Buy every holder out at pricePerUnit and freeze the property. The funder must be the issuer or
+//                       the manager. Open trades and offers are cancelled first so escrowed units are back with their
+//                       holders and the operating account is paid out, then the funder's cash is escrowed on the
+//                       property account and paid out from there
+//==============================================================================================================================
+func (t *SimpleChaincode) reclaimProperty(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    pricePerUnit := args.Float("pricePerUnit")
+    if pricePerUnit <= 0 {return nil, newError(ERR_INVALID_ARGUMENT, "Price per unit must be positive")}
+
+    property, err := getProperty(stub, args.String("propertyID"))
+    if checkErrors(err){return nil, err}
+    if property.closed() {return nil, newError(ERR_STATE_VIOLATION, "Property " + property.ID + " is no longer trading")}
+
+    funder, err := getAccount(stub, args.String("accountID"))
+    if checkErrors(err){return nil, err}
+    if funder.ID != property.Issuer && funder.ID != property.ManagedBy {return nil, newError(ERR_UNAUTHORISED, "Only the issuer or manager can reclaim property " + property.ID)}
+
+    err = cancelPropertyOrders(stub, property.ID)
+    if checkErrors(err){return nil, err}
+    err = closeOperatingAccount(stub, &property, funder.ID)
+    if checkErrors(err){return nil, err}
+
+    var statement ReclaimStatement
+    statement.PropertyID = property.ID
+    statement.FunderID = funder.ID
+    statement.PricePerUnit = pricePerUnit
+    statement.Timestamp, err = getTxTime(stub)
+    if checkErrors(err){return nil, err}
+
+    statement.Payouts, statement.Total, err = payHolders(stub, property.ID, funder.ID, pricePerUnit, false, LEDGER_RECLAIM)
+    if checkErrors(err){return nil, err}
+
+    property.Status = PROPERTY_STATE_RECLAIMED
+    err = property.save(stub)
+    if checkErrors(err){return nil, err}
+
+    log.info("Reclaimed property", "propertyID", property.ID, "funderID", funder.ID, "pricePerUnit", pricePerUnit, "total", statement.Total)
+
+    bytes, err := json.Marshal(statement)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling reclaim statement", err)}
+    return bytes, nil
+}
+
+//==============================================================================================================================
+//     settlePropertySale - The house has been sold. The operating account is paid out, then the manager pays the proceeds
+//                          less costs out to every holder pro rata from their own cash, all units are burned and the
+//                          property is closed as sold
+//==============================================================================================================================
+func (t *SimpleChaincode) settlePropertySale(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    var statement SaleStatement
+    statement.GrossProceeds = args.Float("grossProceeds")
+    statement.Costs = args.Float("costs")
+    if statement.GrossProceeds <= 0 {return nil, newError(ERR_INVALID_ARGUMENT, "Gross proceeds must be positive")}
+    if statement.Costs < 0 || statement.Costs > statement.GrossProceeds {return nil, newError(ERR_INVALID_ARGUMENT, "Costs must be between zero and the gross proceeds")}
+
+    property, err := getProperty(stub, args.String("propertyID"))
+    if checkErrors(err){return nil, err}
+    if property.closed() {return nil, newError(ERR_STATE_VIOLATION, "Property " + property.ID + " is no longer trading")}
+    if property.ManagedBy == "" || args.String("managerID") != property.ManagedBy {return nil, newError(ERR_UNAUTHORISED, "Only the manager can settle the sale of property " + property.ID)}
+    if property.Units <= 0 {return nil, newError(ERR_STATE_VIOLATION, "Property " + property.ID + " has no units")}
+
+    err = cancelPropertyOrders(stub, property.ID)
+    if checkErrors(err){return nil, err}
+    err = closeOperatingAccount(stub, &property, property.ManagedBy)
+    if checkErrors(err){return nil, err}
+
+    statement.PropertyID = property.ID
+    statement.ManagerID = property.ManagedBy
+    statement.NetProceeds = roundCents(statement.GrossProceeds - statement.Costs)
+    statement.Units = property.Units
+    statement.PerUnit = statement.NetProceeds / float64(property.Units)
+    statement.Timestamp, err = getTxTime(stub)
+    if checkErrors(err){return nil, err}
+
+    statement.Payouts, statement.Distributed, err = payHolders(stub, property.ID, property.ManagedBy, statement.PerUnit, true, LEDGER_SALE)
+    if checkErrors(err){return nil, err}
+    statement.Residual = roundCents(statement.NetProceeds - statement.Distributed)
+
+    err = saleStatements.create(stub, &statement)
+    if checkErrors(err){return nil, err}
+
+    property.Units = 0
+    property.Status = PROPERTY_STATE_SOLD
+    err = property.save(stub)
+    if checkErrors(err){return nil, err}
+
+    log.info("Settled property sale", "propertyID", property.ID, "netProceeds", statement.NetProceeds, "perUnit", statement.PerUnit)
+    return statement.marshal()
+}
+
+//==============================================================================================================================
+//     splitUnits - Turn every ratioDen units into ratioNum units, splitting when ratioNum is larger and consolidating
+//                  when it is smaller. Holdings, resting trades, offers and their underwriting are rescaled and prices
+//                  move the other way. Holders are rounded down and the funder, the issuer or manager, pays cash in lieu
+//                  of the fraction at the rescaled reference price. A trade or offer rounded down to nothing is cancelled
+//==============================================================================================================================
+func (t *SimpleChaincode) splitUnits(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    num, den := args.Int("ratioNum"), args.Int("ratioDen")
+    if num <= 0 || den <= 0 || num == den {return nil, newError(ERR_INVALID_ARGUMENT, "The split ratio must be two different positive numbers")}
+
+    property, err := getProperty(stub, args.String("propertyID"))
+    if checkErrors(err){return nil, err}
+    if property.closed() {return nil, newError(ERR_STATE_VIOLATION, "Property " + property.ID + " is no longer trading")}
+
+    funder, err := getAccount(stub, args.String("accountID"))
+    if checkErrors(err){return nil, err}
+    if funder.ID != property.Issuer && funder.ID != property.ManagedBy {return nil, newError(ERR_UNAUTHORISED, "Only the issuer or manager can split property " + property.ID)}
+
+    var statement SplitStatement
+    statement.PropertyID = property.ID
+    statement.FunderID = funder.ID
+    statement.RatioNum = num
+    statement.RatioDen = den
+    statement.ReferencePrice = property.referencePrice() * float64(den) / float64(num)
+    statement.Payouts = []Payout{}
+    statement.Timestamp, err = getTxTime(stub)
+    if checkErrors(err){return nil, err}
+
+    //rescale the book and offers first so each holder's escrowed sell units are known
+    resting, err := getPropertyTrades(stub, property.ID)
+    if checkErrors(err){return nil, err}
+    escrowed := map[string]int{}
+    for i := 0; i < len(resting); i++ {
+        trade := &resting[i]
+        trade.Units = trade.Units * num / den
+        trade.Price = trade.Price * float64(den) / float64(num)
+        trade.StopPrice = trade.StopPrice * float64(den) / float64(num)
+        if trade.Direction == TRADE_SELL {escrowed[trade.AccountID] += trade.Units}
+    }
+
+    err = rescaleOffers(stub, property.ID, num, den, escrowed)
+    if checkErrors(err){return nil, err}
+
+    propertyAccount, err := getAccount(stub, property.ID)
+    if checkErrors(err){return nil, err}
+    holders := append([]Holding{}, propertyAccount.Holdings...)
+    propertyAccount.Holdings = []Holding{}
+    property.Units = 0
+
+    for i := 0; i < len(holders); i++ {
+        scaled := holders[i].Units * num
+        payout := Payout{AccountID: holders[i].Entity, Units: scaled / den}
+        if payout.AccountID != funder.ID {
+            payout.Amount = roundCents(statement.ReferencePrice * float64(scaled % den) / float64(den))
+        }
+
+        holder, err := getAccount(stub, payout.AccountID)
+        if checkErrors(err){return nil, err}
+        err = holder.changeHolding(property.ID, payout.Units - escrowed[holder.ID] - holder.getHolding(property.ID))
+        if checkErrors(err){return nil, err}
+        holder.Cash += payout.Amount
+        err = holder.save(stub)
+        if checkErrors(err){return nil, err}
+        if payout.Amount > 0 {
+            err = postLedgerEntry(stub, holder, LEDGER_CASH_IN_LIEU, payout.Amount, 0, property.ID + ":" + funder.ID)
+            if checkErrors(err){return nil, err}
+        }
+
+        err = propertyAccount.changeHolding(payout.AccountID, payout.Units)
+        if checkErrors(err){return nil, err}
+        property.Units += payout.Units
+        statement.CashInLieu += payout.Amount
+        statement.Payouts = append(statement.Payouts, payout)
+    }
+    statement.CashInLieu = roundCents(statement.CashInLieu)
+    statement.Units = property.Units
+
+    funder, err = getAccount(stub, funder.ID)
+    if checkErrors(err){return nil, err}
+    if funder.Cash < statement.CashInLieu {return nil, newError(ERR_INSUFFICIENT_FUNDS, "Not enough cash to pay cash in lieu")}
+    funder.Cash -= statement.CashInLieu
+    err = funder.save(stub)
+    if checkErrors(err){return nil, err}
+    if statement.CashInLieu > 0 {
+        err = postLedgerEntry(stub, funder, LEDGER_CASH_IN_LIEU, -statement.CashInLieu, 0, property.ID)
+        if checkErrors(err){return nil, err}
+    }
+
+    err = propertyAccount.save(stub)
+    if checkErrors(err){return nil, err}
+
+    for i := 0; i < len(resting); i++ {
+        if resting[i].Units > 0 {
+            err = resting[i].save(stub)
+        } else {
+            //a sell has nothing left in escrow, a buy gets its cash back
+            err = resting[i].cancel(stub, TRADE_STATE_CANCELLED)
+        }
+        if checkErrors(err){return nil, err}
+    }
+
+    property.LastPrice = property.LastPrice * float64(den) / float64(num)
+    err = property.save(stub)
+    if checkErrors(err){return nil, err}
+
+    log.info("Split property units", "propertyID", property.ID, "ratioNum", num, "ratioDen", den, "units", property.Units, "cashInLieu", statement.CashInLieu)
+
+    bytes, err := json.Marshal(statement)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling split statement", err)}
+    return bytes, nil
+}
+
+//==============================================================================================================================
+//     issueUnits - Mint more units of a property to its issuer, who pays the issuance fee. When a rights period is given
+//                  each other holder, or holder of record in the snapshot given, is offered their pro rata share at price,
+//                  rounded down, for that many seconds. The offered units are escrowed on the offers so the issuer can't
+//                  sell them elsewhere, and whatever isn't taken up goes back to the issuer when the offer is closed.
+//                  Whatever isn't offered stays with the issuer, who can offer it to everyone later
+//==============================================================================================================================
+func (t *SimpleChaincode) issueUnits(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    units := args.Int("units")
+    price := args.Float("price")
+    rightsPeriod := int64(args.Int("rightsPeriod"))
+    if units <= 0 {return nil, newError(ERR_INVALID_ARGUMENT, "Must issue a positive number of units")}
+    if rightsPeriod < 0 {return nil, newError(ERR_INVALID_ARGUMENT, "Rights period can't be negative")}
+    if price < 0 || (rightsPeriod > 0 && price == 0) {return nil, newError(ERR_INVALID_ARGUMENT, "Rights must be offered at a positive price")}
+
+    property, err := getProperty(stub, args.String("propertyID"))
+    if checkErrors(err){return nil, err}
+    if property.closed() {return nil, newError(ERR_STATE_VIOLATION, "Property " + property.ID + " is no longer trading")}
+
+    issuer, err := getAccount(stub, args.String("issuerID"))
+    if checkErrors(err){return nil, err}
+    if issuer.ID != property.Issuer {return nil, newError(ERR_UNAUTHORISED, "Only the issuer can issue more units of property " + property.ID)}
+
+    schedule := config.Fees
+    fee := schedule.flatFee(schedule.IssuanceFee, issuer.Role)
+    if issuer.Cash < fee {return nil, newError(ERR_INSUFFICIENT_FUNDS, "Not enough cash to pay the issuance fee")}
+    issuer.Cash -= fee
+    err = issuer.changeHolding(property.ID, units)
+    if checkErrors(err){return nil, err}
+    err = issuer.save(stub)
+    if checkErrors(err){return nil, err}
+
+    if fee > 0 {
+        err = postLedgerEntry(stub, issuer, LEDGER_ISSUANCE_FEE, 0, fee, property.ID)
+        if checkErrors(err){return nil, err}
+        err = collectFee(stub, schedule, fee, issuer.ID, property.ID)
+        if checkErrors(err){return nil, err}
+    }
+
+    holders, held, err := holdersOfRecord(stub, property, args.String("snapshotID"))
+    if checkErrors(err){return nil, err}
+
+    propertyAccount, err := getAccount(stub, property.ID)
+    if checkErrors(err){return nil, err}
+    err = propertyAccount.changeHolding(issuer.ID, units)
+    if checkErrors(err){return nil, err}
+    err = propertyAccount.save(stub)
+    if checkErrors(err){return nil, err}
+
+    result := IssueResult{PropertyID: property.ID, Units: units, Price: price, Rights: []Offer{}}
+    if rightsPeriod > 0 {
+        now, err := getTxTime(stub)
+        if checkErrors(err){return nil, err}
+
+        for i := 0; i < len(holders); i++ {
+            if holders[i].Entity == issuer.ID {continue}
+            share := units * holders[i].Units / held
+            if share == 0 {continue}
+
+            offer := Offer{PropertyID: property.ID, Seller: issuer.ID, Direction: TRADE_BUY, Units: share, Price: price, Buyer: holders[i].Entity, Expiry: now + rightsPeriod, Escrowed: true}
+            err = offer.create(stub)
+            if checkErrors(err){return nil, err}
+            err = issuer.changeHolding(property.ID, -share)
+            if checkErrors(err){return nil, err}
+            result.Rights = append(result.Rights, offer)
+        }
+
+        err = issuer.save(stub)
+        if checkErrors(err){return nil, err}
+    }
+
+    property.Units += units
+    err = property.save(stub)
+    if checkErrors(err){return nil, err}
+
+    log.info("Issued units", "propertyID", property.ID, "units", units, "price", price, "rights", len(result.Rights))
+
+    bytes, err := json.Marshal(result)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling issue", err)}
+    return bytes, nil
+}
+
+//==============================================================================================================================
+//     Corporate Action Subroutines
+//==============================================================================================================================
+//     cancelPropertyOrders - Take every trade and offer for the property off the book, releasing their escrow
+//==============================================================================================================================
+func cancelPropertyOrders(stub *shim.ChaincodeStub, propertyID string) error {
+    resting, err := getPropertyTrades(stub, propertyID)
+    if checkErrors(err){return err}
+
+    for i := 0; i < len(resting); i++ {
+        err = resting[i].cancel(stub, TRADE_STATE_CANCELLED)
+        if checkErrors(err){return err}
+    }
+
+    ids, err := offers.list(stub, "property", propertyID)
+    if checkErrors(err){return err}
+
+    for i := 0; i < len(ids); i++ {
+        offer, err := getOffer(stub, ids[i])
+        if checkErrors(err){return err}
+        err = offer.withdraw(stub)
+        if checkErrors(err){return err}
+        err = releaseUnderwriting(stub, offer.ID)
+        if checkErrors(err){return err}
+    }
+
+    log.info("Cancelled property orders", "propertyID", propertyID, "trades", len(resting), "offers", len(ids))
+    return nil
+}
+
+//==============================================================================================================================
+//     rescaleOffers - Scale each of the property's offers, and the commitment underwriting it, by num/den with prices
+//                     scaled the other way. Units are rounded down, and an offer or commitment left with none is cancelled.
+//                     The rescaled units escrowed on offers are added to the seller's escrowed units
+//==============================================================================================================================
+func rescaleOffers(stub *shim.ChaincodeStub, propertyID string, num int, den int, escrowed map[string]int) error {
+    ids, err := offers.list(stub, "property", propertyID)
+    if checkErrors(err){return err}
+
+    for i := 0; i < len(ids); i++ {
+        offer, err := getOffer(stub, ids[i])
+        if checkErrors(err){return err}
+        offer.Units = offer.Units * num / den
+        offer.Price = offer.Price * float64(den) / float64(num)
+        offer.MinFloorPrice = offer.MinFloorPrice * float64(den) / float64(num)
+
+        if offer.Units == 0 {
+            err = offer.withdraw(stub)
+            if checkErrors(err){return err}
+            err = releaseUnderwriting(stub, offer.ID)
+            if checkErrors(err){return err}
+            continue
+        }
+        if offer.Escrowed {escrowed[offer.Seller] += offer.Units}
+        err = offer.save(stub)
+        if checkErrors(err){return err}
+
+        underwriting, err := getUnderwriting(stub, underwritingID(offer.ID))
+        if isNotFound(err) {continue}
+        if checkErrors(err){return err}
+        if underwriting.Status != UNDERWRITING_OPEN {continue}
+
+        //the escrow was worked out at the old floor price and still covers the rescaled commitment, which costs no more
+        underwriting.Units = underwriting.Units * num / den
+        if underwriting.Units > offer.Units {underwriting.Units = offer.Units}
+        underwriting.FloorPrice = underwriting.FloorPrice * float64(den) / float64(num)
+        if underwriting.Units == 0 {
+            err = releaseUnderwriting(stub, offer.ID)
+        } else {
+            err = underwritings.save(stub, &underwriting)
+        }
+        if checkErrors(err){return err}
+    }
+    return nil
+}
+
+//==============================================================================================================================
+//     payHolders - Pay every holder on the property's cap table perUnit for each unit and take the units away. The total
+//                  comes out of the funder's cash into escrow on the property account and is paid out from there.
+//                  The funder's own units are paid for only when payFunder is set. Each payout is rounded down to the cent
+//                  so together they never come to more than the funder meant to pay
+//==============================================================================================================================
+func payHolders(stub *shim.ChaincodeStub, propertyID string, funderID string, perUnit float64, payFunder bool, entryType string) ([]Payout, float64, error) {
+    payouts := []Payout{}
+    var total float64
+
+    propertyAccount, err := getAccount(stub, propertyID)
+    if checkErrors(err){return nil, 0, err}
+    for i := 0; i < len(propertyAccount.Holdings); i++ {
+        payout := Payout{AccountID: propertyAccount.Holdings[i].Entity, Units: propertyAccount.Holdings[i].Units}
+        if payFunder || payout.AccountID != funderID {payout.Amount = floorCents(perUnit * float64(payout.Units))}
+        total += payout.Amount
+        payouts = append(payouts, payout)
+    }
+    total = roundCents(total)
+
+    funder, err := getAccount(stub, funderID)
+    if checkErrors(err){return nil, 0, err}
+    if funder.Cash < total {return nil, 0, newError(ERR_INSUFFICIENT_FUNDS, "Not enough cash to pay the holders")}
+    funder.Cash -= total
+    err = funder.save(stub)
+    if checkErrors(err){return nil, 0, err}
+    err = postLedgerEntry(stub, funder, entryType, -total, 0, propertyID)
+    if checkErrors(err){return nil, 0, err}
+    propertyAccount.Cash += total
+
+    for i := 0; i < len(payouts); i++ {
+        holder, err := getAccount(stub, payouts[i].AccountID)
+        if checkErrors(err){return nil, 0, err}
+        err = holder.changeHolding(propertyID, -payouts[i].Units)
+        if checkErrors(err){return nil, 0, err}
+        holder.Cash += payouts[i].Amount
+        err = holder.save(stub)
+        if checkErrors(err){return nil, 0, err}
+
+        if payouts[i].Amount > 0 {
+            err = postLedgerEntry(stub, holder, entryType, payouts[i].Amount, 0, propertyID + ":" + funderID)
+            if checkErrors(err){return nil, 0, err}
+        }
+
+        propertyAccount.Cash -= payouts[i].Amount
+        err = propertyAccount.changeHolding(payouts[i].AccountID, -payouts[i].Units)
+        if checkErrors(err){return nil, 0, err}
+    }
+
+    propertyAccount.Cash = roundCents(propertyAccount.Cash)
+    return payouts, total, propertyAccount.save(stub)
+}
+
+func (object *SaleStatement) getID() string {return object.PropertyID}
+func (object *SaleStatement) getVersion() int {return object.Version}
+func (object *SaleStatement) setVersion(version int) {object.Version = version}
+
+//==============================================================================================================================
+//     Parsing Subroutines
+//==============================================================================================================================
+func (object *SaleStatement) marshal() ([]byte, error) {
+    bytes, err := json.Marshal(object)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling sale statement", err)}
+    return bytes, nil
+}
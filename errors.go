@@ -0,0 +1,85 @@
+package main
+
+import (
+    "errors"
+    "encoding/json"
+)
+
+const   ERR_NOT_FOUND           = "NOT_FOUND"
+const   ERR_INSUFFICIENT_FUNDS  = "INSUFFICIENT_FUNDS"
+const   ERR_INSUFFICIENT_UNITS  = "INSUFFICIENT_UNITS"
+const   ERR_UNAUTHORISED        = "UNAUTHORISED"
+const   ERR_INVALID_ARGUMENT    = "INVALID_ARGUMENT"
+const   ERR_CONFLICT            = "CONFLICT"
+const   ERR_STATE_VIOLATION     = "STATE_VIOLATION"
+const   ERR_INTERNAL            = "INTERNAL"
+
+//==============================================================================================================================
+//    ChaincodeError - An error with a stable code clients can switch on. Cause is the underlying error, if any, so a
+//                     missing record (NOT_FOUND) can be told apart from one that failed to unmarshal (INTERNAL)
+//==============================================================================================================================
+type ChaincodeError struct {
+    Code            string
+    Message         string
+    Cause           error
+}
+
+//==============================================================================================================================
+//    ErrorEnvelope - What the client receives in place of the error text
+//==============================================================================================================================
+type ErrorEnvelope struct {
+    Error           ErrorBody   `json:"error"`
+}
+
+type ErrorBody struct {
+    Code            string      `json:"code"`
+    Message         string      `json:"message"`
+    Cause           string      `json:"cause,omitempty"`
+}
+
+func (e *ChaincodeError) Error() string {
+    if e.Cause != nil {return e.Message + ": " + e.Cause.Error()}
+    return e.Message
+}
+
+//==============================================================================================================================
+//     newError / wrapError - Construct coded errors
+//==============================================================================================================================
+func newError(code string, message string) error {
+    return &ChaincodeError{Code: code, Message: message}
+}
+
+func wrapError(code string, message string, cause error) error {
+    return &ChaincodeError{Code: code, Message: message, Cause: cause}
+}
+
+//==============================================================================================================================
+//     errorCode - The code of the error, errors without one are INTERNAL
+//==============================================================================================================================
+func errorCode(err error) string {
+    if chaincodeErr, ok := err.(*ChaincodeError); ok {return chaincodeErr.Code}
+    return ERR_INTERNAL
+}
+
+func isNotFound(err error) bool {
+    return checkErrors(err) && errorCode(err) == ERR_NOT_FOUND
+}
+
+//==============================================================================================================================
+//     errorEnvelope - Convert an error into the JSON envelope returned to clients
+//==============================================================================================================================
+func errorEnvelope(err error) error {
+    if !checkErrors(err) {return nil}
+
+    var envelope ErrorEnvelope
+    envelope.Error.Code = errorCode(err)
+    envelope.Error.Message = err.Error()
+    if chaincodeErr, ok := err.(*ChaincodeError); ok {
+        envelope.Error.Message = chaincodeErr.Message
+        if chaincodeErr.Cause != nil {envelope.Error.Cause = chaincodeErr.Cause.Error()}
+    }
+
+    bytes, marshalErr := json.Marshal(envelope)
+    if checkErrors(marshalErr) {return err}
+    return errors.New(string(bytes))
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//    FeeSchedule - Trade fees are charged in basis points of the notional, issuance and withdrawal fees are flat.
+//                  RoleDiscounts maps an account role to a discount in basis points of the fee. Every fee collected is
+//                  credited to FeeAccount
+//==============================================================================================================================
+type FeeSchedule struct {
+    MakerBps        int             `json:"makerBps"`
+    TakerBps        int             `json:"takerBps"`
+    IssuanceFee     float64         `json:"issuanceFee"`
+    WithdrawalFee   float64         `json:"withdrawalFee"`
+    RoleDiscounts   map[string]int  `json:"roleDiscounts"`
+    FeeAccount      string          `json:"feeAccount"`
+}
+
+//==============================================================================================================================
+//    LedgerEntry - A single movement of cash on an account. Amount is the principal, Fee is charged on top of it
+//==============================================================================================================================
+type LedgerEntry struct {
+    ID              string      `json:"entryID"`
+    AccountID       string      `json:"accountID"`
+    Type            string      `json:"type"`
+    Amount          float64     `json:"amount"`
+    Fee             float64     `json:"fee"`
+    Balance         float64     `json:"balance"`
+    Reference       string      `json:"reference,omitempty"`
+    Timestamp       int64       `json:"timestamp"`
+    Version         int         `json:"version"`
+}
+
+//==============================================================================================================================
+//     Query Logic Methods
+//==============================================================================================================================
+//     getFeeSchedule
+//==============================================================================================================================
+func (t *SimpleChaincode) getFeeSchedule(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    return config.Fees.marshal()
+}
+
+//==============================================================================================================================
+//     getLedger - All ledger entries for an account, oldest first
+//==============================================================================================================================
+func (t *SimpleChaincode) getLedger(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    accountID := args.String("accountID")
+
+    ids, err := ledgerEntries.list(stub, "account", accountID)
+    if checkErrors(err){return nil, err}
+
+    entries := []LedgerEntry{}
+    for i := 0; i < len(ids); i++ {
+        var entry LedgerEntry
+        err = ledgerEntries.get(stub, ids[i], &entry)
+        if checkErrors(err){return nil, err}
+        entries = append(entries, entry)
+    }
+
+    return marshalLedgerEntries(entries)
+}
+
+//==============================================================================================================================
+//     CRUD Subroutines
+//==============================================================================================================================
+//     FeeSchedule - Stored as part of the configuration, an empty schedule charges no fees
+//==============================================================================================================================
+func (object *FeeSchedule) validate() error {
+    if object.MakerBps < 0 || object.MakerBps > 10000 {return newError(ERR_INVALID_ARGUMENT, "Maker fee must be between 0 and 10000 basis points")}
+    if object.TakerBps < 0 || object.TakerBps > 10000 {return newError(ERR_INVALID_ARGUMENT, "Taker fee must be between 0 and 10000 basis points")}
+    if object.IssuanceFee < 0 {return newError(ERR_INVALID_ARGUMENT, "Issuance fee can't be negative")}
+    if object.WithdrawalFee < 0 {return newError(ERR_INVALID_ARGUMENT, "Withdrawal fee can't be negative")}
+
+    for role, discount := range object.RoleDiscounts {
+        value, err := strconv.Atoi(role)
+        if checkErrors(err) || value < ROLE_MARKET_MAKER || value > ROLE_ADMIN {return newError(ERR_INVALID_ARGUMENT, "Invalid role " + role + " in fee discounts")}
+        if discount < 0 || discount > 10000 {return newError(ERR_INVALID_ARGUMENT, "Fee discounts must be between 0 and 10000 basis points")}
+    }
+
+    charging := object.MakerBps > 0 || object.TakerBps > 0 || object.IssuanceFee > 0 || object.WithdrawalFee > 0
+    if charging && object.FeeAccount == "" {return newError(ERR_INVALID_ARGUMENT, "A fee account is required to collect fees")}
+
+    return nil
+}
+
+//==============================================================================================================================
+//     Fee Calculation
+//==============================================================================================================================
+func (object *FeeSchedule) discount(role int) float64 {
+    return float64(object.RoleDiscounts[strconv.Itoa(role)]) / 10000
+}
+
+//flatFee - the fee after the role's discount, rounded to the cent
+func (object *FeeSchedule) flatFee(fee float64, role int) float64 {
+    return roundCents(fee * (1 - object.discount(role)))
+}
+
+func (object *FeeSchedule) tradeFee(notional float64, role int, maker bool) float64 {
+    bps := object.TakerBps
+    if maker {bps = object.MakerBps}
+    return object.flatFee(notional * float64(bps) / 10000, role)
+}
+
+//maxTradeFee - the most we could charge on the notional, used to size escrow before we know who is the maker
+func (object *FeeSchedule) maxTradeFee(notional float64, role int) float64 {
+    makerFee := object.tradeFee(notional, role, true)
+    takerFee := object.tradeFee(notional, role, false)
+    if makerFee > takerFee {return makerFee}
+    return takerFee
+}
+
+//==============================================================================================================================
+//     collectFee - Credit a fee already taken from the payer to the exchange's fee account
+//==============================================================================================================================
+func collectFee(stub *shim.ChaincodeStub, schedule FeeSchedule, fee float64, payerID string, reference string) error {
+    if fee <= 0 {return nil}
+    if schedule.FeeAccount == "" {return newError(ERR_STATE_VIOLATION, "No fee account configured")}
+
+    account, err := getAccount(stub, schedule.FeeAccount)
+    if checkErrors(err){return err}
+
+    account.Cash += fee
+    err = account.save(stub)
+    if checkErrors(err){return err}
+
+    return postLedgerEntry(stub, account, LEDGER_FEE, fee, 0, reference + ":" + payerID)
+}
+
+//==============================================================================================================================
+//     postLedgerEntry - Record a cash movement against the account. Call after the account has been updated so the entry
+//                       carries the resulting balance. Entries are indexed by account then time so they can be range queried
+//==============================================================================================================================
+func postLedgerEntry(stub *shim.ChaincodeStub, account Account, entryType string, amount float64, fee float64, reference string) error {
+    var entry LedgerEntry
+    var err error
+    entry.AccountID = account.ID
+    entry.Type = entryType
+    entry.Amount = amount
+    entry.Fee = fee
+    entry.Balance = account.Cash
+    entry.Reference = reference
+    entry.Timestamp, err = getTxTime(stub)
+    if checkErrors(err){return err}
+    entry.ID = getMd5Hash(getTxID(stub) + entry.AccountID + entry.Type + entry.Reference)
+
+    return ledgerEntries.create(stub, &entry)
+}
+
+func (object *LedgerEntry) getID() string {return object.ID}
+func (object *LedgerEntry) getVersion() int {return object.Version}
+func (object *LedgerEntry) setVersion(version int) {object.Version = version}
+
+func (object *LedgerEntry) indexes() map[string][]string {
+    return map[string][]string{"account": {indexValue(object.AccountID, fmt.Sprintf("%019d", object.Timestamp))}}
+}
+
+//==============================================================================================================================
+//     Parsing Subroutines
+//==============================================================================================================================
+func (object *FeeSchedule) marshal() ([]byte, error) {
+    bytes, err := json.Marshal(object)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling fee schedule", err)}
+    return bytes, nil
+}
+
+func marshalLedgerEntries(objects []LedgerEntry) ([]byte, error) {
+    bytes, err := json.Marshal(objects)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling ledger entry array", err)}
+    return bytes, nil
+}
+
+func (object *LedgerEntry) marshal() ([]byte, error) {
+    bytes, err := json.Marshal(object)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling ledger entry", err)}
+    return bytes, nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+    "strconv"
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const   PROPOSAL_OPEN       =  0
+const   PROPOSAL_CLOSED     =  1
+
+//==============================================================================================================================
+//    Proposal - A question put to a property's holders. Each holder votes with the units the cap table gave them when
+//               the proposal was created, or in the snapshot it was created against, which already includes units
+//               escrowed in open sell trades, so trading after that changes nothing. On closing, the option with the
+//               most units wins if the units that voted make quorum. A tie, or a vote that misses quorum, has no outcome
+//==============================================================================================================================
+type Proposal struct {
+    ID              string              `json:"proposalID"`
+    PropertyID      string              `json:"propertyID"`
+    ProposedBy      string              `json:"proposedBy"`
+    SnapshotID      string              `json:"snapshotID,omitempty"`
+    Description     string              `json:"description"`
+    Options         []string            `json:"options"`
+    Created         int64               `json:"created"`
+    ClosesAt        int64               `json:"closesAt"`
+    QuorumPct       float64             `json:"quorumPct"`
+    Units           int                 `json:"units"`
+    Snapshot        map[string]int      `json:"snapshot"`
+    Votes           map[string]string   `json:"votes"`
+    Tally           map[string]int      `json:"tally,omitempty"`
+    Voted           int                 `json:"voted"`
+    QuorumMet       bool                `json:"quorumMet"`
+    Outcome         string              `json:"outcome,omitempty"`
+    Status          int                 `json:"status"`
+    Version         int                 `json:"version"`
+}
+
+//==============================================================================================================================
+//     Query Logic Methods
+//==============================================================================================================================
+//     getProposal
+//==============================================================================================================================
+func (t *SimpleChaincode) getProposal(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    proposal, err := getProposal(stub, args.String("proposalID"))
+    if checkErrors(err){return nil, err}
+
+    return proposal.marshal()
+}
+
+//==============================================================================================================================
+//     getProposals - A property's proposals, optionally only those with the given status
+//==============================================================================================================================
+func (t *SimpleChaincode) getProposals(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    ids, err := proposals.list(stub, "property", args.String("propertyID"))
+    if checkErrors(err){return nil, err}
+
+    objects := []Proposal{}
+    for i := 0; i < len(ids); i++ {
+        proposal, err := getProposal(stub, ids[i])
+        if checkErrors(err){return nil, err}
+        if args.Has("status") && proposal.Status != args.Int("status") {continue}
+        objects = append(objects, proposal)
+    }
+
+    bytes, err := json.Marshal(objects)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling proposal array", err)}
+    return bytes, nil
+}
+
+//==============================================================================================================================
+//     Invoke Logic Methods
+//==============================================================================================================================
+//     createProposal - A holder or the manager puts a question to the holders and the cap table is snapshotted
+//==============================================================================================================================
+func (t *SimpleChaincode) createProposal(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    proposal := Proposal{PropertyID: args.String("propertyID"), ProposedBy: args.String("accountID"), Description: args.String("description"),
+        Options: *args.Body("options").(*[]string), ClosesAt: int64(args.Int("closesAt")), QuorumPct: args.Float("quorumPct"), SnapshotID: args.String("snapshotID")}
+
+    _, err := proposal.open(stub)
+    if checkErrors(err){return nil, err}
+
+    return proposal.marshal()
+}
+
+//==============================================================================================================================
+//     castVote - A holder in the snapshot votes for one of the options. Each holder votes once
+//==============================================================================================================================
+func (t *SimpleChaincode) castVote(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    accountID := args.String("accountID")
+    option := args.String("option")
+
+    proposal, err := getProposal(stub, args.String("proposalID"))
+    if checkErrors(err){return nil, err}
+
+    now, err := getTxTime(stub)
+    if checkErrors(err){return nil, err}
+    if proposal.Status != PROPOSAL_OPEN || now >= proposal.ClosesAt {return nil, newError(ERR_STATE_VIOLATION, "Proposal " + proposal.ID + " is closed")}
+    if proposal.Snapshot[accountID] <= 0 {return nil, newError(ERR_UNAUTHORISED, accountID + " held no units of record for proposal " + proposal.ID)}
+    if _, voted := proposal.Votes[accountID]; voted {return nil, newError(ERR_CONFLICT, accountID + " has already voted on proposal " + proposal.ID)}
+    if !proposal.hasOption(option) {return nil, newError(ERR_INVALID_ARGUMENT, "Invalid option " + option)}
+
+    proposal.Votes[accountID] = option
+    err = proposals.save(stub, &proposal)
+    if checkErrors(err){return nil, err}
+
+    log.info("Cast vote", "proposalID", proposal.ID, "accountID", accountID, "option", option, "units", proposal.Snapshot[accountID])
+    return proposal.marshal()
+}
+
+//==============================================================================================================================
+//     closeProposal - Once voting has closed anyone can tally the votes and record the outcome. A vote on a manager
+//                     nomination then appoints or drops the nominee
+//==============================================================================================================================
+func (t *SimpleChaincode) closeProposal(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    proposal, err := getProposal(stub, args.String("proposalID"))
+    if checkErrors(err){return nil, err}
+    if proposal.Status != PROPOSAL_OPEN {return nil, newError(ERR_STATE_VIOLATION, "Proposal " + proposal.ID + " has already been closed")}
+
+    now, err := getTxTime(stub)
+    if checkErrors(err){return nil, err}
+    if now < proposal.ClosesAt {return nil, newError(ERR_STATE_VIOLATION, "Voting on proposal " + proposal.ID + " closes at " + strconv.FormatInt(proposal.ClosesAt, 10))}
+
+    proposal.tally()
+    proposal.Status = PROPOSAL_CLOSED
+    err = proposals.save(stub, &proposal)
+    if checkErrors(err){return nil, err}
+
+    err = resolveNomination(stub, proposal)
+    if checkErrors(err){return nil, err}
+
+    log.info("Closed proposal", "proposalID", proposal.ID, "voted", proposal.Voted, "quorumMet", proposal.QuorumMet, "outcome", proposal.Outcome)
+    return proposal.marshal()
+}
+
+//==============================================================================================================================
+//     Governance Subroutines
+//==============================================================================================================================
+//     open - Check the proposal and the proposer, who must hold units or manage the property, record the holders of
+//            record and save it open for voting. Returns the property
+//==============================================================================================================================
+func (object *Proposal) open(stub *shim.ChaincodeStub) (Property, error) {
+    err := object.validate()
+    if checkErrors(err){return Property{}, err}
+
+    property, err := getProperty(stub, object.PropertyID)
+    if checkErrors(err){return property, err}
+    if property.closed() {return property, newError(ERR_STATE_VIOLATION, "Property " + property.ID + " is no longer trading")}
+
+    propertyAccount, err := getAccount(stub, property.ID)
+    if checkErrors(err){return property, err}
+    if object.ProposedBy != property.ManagedBy && propertyAccount.getHolding(object.ProposedBy) <= 0 {
+        return property, newError(ERR_UNAUTHORISED, "Only holders or the manager of property " + property.ID + " can make a proposal")
+    }
+
+    object.Created, err = getTxTime(stub)
+    if checkErrors(err){return property, err}
+    if object.ClosesAt <= object.Created {return property, newError(ERR_INVALID_ARGUMENT, "A proposal must close in the future")}
+
+    holders, units, err := holdersOfRecord(stub, property, object.SnapshotID)
+    if checkErrors(err){return property, err}
+
+    object.Units = units
+    object.Snapshot = map[string]int{}
+    for i := 0; i < len(holders); i++ {
+        if holders[i].Units <= 0 {continue}
+        object.Snapshot[holders[i].Entity] = holders[i].Units
+    }
+    object.Votes = map[string]string{}
+    object.ID = getMd5Hash(getTxID(stub) + object.PropertyID)
+    object.Status = PROPOSAL_OPEN
+
+    err = proposals.create(stub, object)
+    if checkErrors(err){return property, err}
+
+    log.info("Created proposal", "proposalID", object.ID, "propertyID", object.PropertyID, "holders", len(object.Snapshot))
+    return property, nil
+}
+
+func (object *Proposal) validate() error {
+    if object.Description == "" {return newError(ERR_INVALID_ARGUMENT, "A proposal needs a description")}
+    if len(object.Options) < 2 {return newError(ERR_INVALID_ARGUMENT, "A proposal needs at least two options")}
+    if object.QuorumPct < 0 || object.QuorumPct > 100 {return newError(ERR_INVALID_ARGUMENT, "Quorum must be between 0 and 100 percent")}
+
+    seen := map[string]bool{}
+    for i := 0; i < len(object.Options); i++ {
+        if object.Options[i] == "" || seen[object.Options[i]] {return newError(ERR_INVALID_ARGUMENT, "Options must be distinct and not empty")}
+        seen[object.Options[i]] = true
+    }
+    return nil
+}
+
+func (object *Proposal) hasOption(option string) bool {
+    for i := 0; i < len(object.Options); i++ {
+        if object.Options[i] == option {return true}
+    }
+    return false
+}
+
+//tally - count the units behind each option. Options are walked in order so the result is deterministic
+func (object *Proposal) tally() {
+    object.Tally = map[string]int{}
+    object.Voted = 0
+    for accountID, option := range object.Votes {
+        object.Tally[option] += object.Snapshot[accountID]
+        object.Voted += object.Snapshot[accountID]
+    }
+
+    object.QuorumMet = float64(object.Voted) * 100 >= object.QuorumPct * float64(object.Units)
+    object.Outcome = ""
+    if !object.QuorumMet {return}
+
+    var most int
+    for i := 0; i < len(object.Options); i++ {
+        units := object.Tally[object.Options[i]]
+        if units > most {
+            most = units
+            object.Outcome = object.Options[i]
+        } else if units == most {
+            object.Outcome = ""
+        }
+    }
+}
+
+//==============================================================================================================================
+//     CRUD Subroutines
+//==============================================================================================================================
+func getProposal(stub *shim.ChaincodeStub, id string) (Proposal, error) {
+    var object Proposal
+    err := proposals.get(stub, id, &object)
+    return object, err
+}
+
+func (object *Proposal) getID() string {return object.ID}
+func (object *Proposal) getVersion() int {return object.Version}
+func (object *Proposal) setVersion(version int) {object.Version = version}
+
+func (object *Proposal) indexes() map[string][]string {
+    return map[string][]string{"property": {indexValue(object.PropertyID)}}
+}
+
+//==============================================================================================================================
+//     Parsing Subroutines
+//==============================================================================================================================
+func (object *Proposal) marshal() ([]byte, error) {
+    bytes, err := json.Marshal(object)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling proposal", err)}
+    return bytes, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const   REQUEST_ID_ARG      = "clientRequestID"
+
+//==============================================================================================================================
+//    RequestRecord - The result of an invoke made with a client request ID, so a retry returns the same result without
+//                    running again. Fingerprint is a hash of the function and its arguments. Only invokes that succeed
+//                    are recorded because a failed invoke writes nothing, so retrying a failure runs it again.
+//                    A record stops counting once it expires and purgeRequestRecords removes it
+//==============================================================================================================================
+type RequestRecord struct {
+    ID              string      `json:"clientRequestID"`
+    Function        string      `json:"function"`
+    Fingerprint     string      `json:"fingerprint"`
+    Result          string      `json:"result"`
+    TxID            string      `json:"txID"`
+    Created         int64       `json:"created"`
+    Expires         int64       `json:"expires,omitempty"`
+    Version         int         `json:"version"`
+}
+
+//==============================================================================================================================
+//     Query Logic Methods
+//==============================================================================================================================
+//     getRequestOutcome - What the invoke made with the request ID returned
+//==============================================================================================================================
+func (t *SimpleChaincode) getRequestOutcome(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    requestID := args.String(REQUEST_ID_ARG)
+
+    record, found, err := getRequestRecord(stub, requestID)
+    if checkErrors(err){return nil, err}
+    if !found {return nil, newError(ERR_NOT_FOUND, "No outcome recorded for request " + requestID)}
+
+    return record.marshal()
+}
+
+//==============================================================================================================================
+//     Invoke Logic Methods
+//==============================================================================================================================
+//     purgeRequestRecords - Delete the records that have expired. The registry makes sure only an admin can do this
+//==============================================================================================================================
+func (t *SimpleChaincode) purgeRequestRecords(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    now, err := getTxTime(stub)
+    if checkErrors(err){return nil, err}
+
+    ids, err := requestRecords.listBetween(stub, "expires", indexValue(fmt.Sprintf("%019d", 1)), indexValue(fmt.Sprintf("%019d", now + 1)))
+    if checkErrors(err){return nil, err}
+
+    for i := 0; i < len(ids); i++ {
+        var record RequestRecord
+        err = requestRecords.get(stub, ids[i], &record)
+        if checkErrors(err){return nil, err}
+        err = requestRecords.delete(stub, &record)
+        if checkErrors(err){return nil, err}
+    }
+
+    log.info("Purged request records", "purged", len(ids))
+    return []byte(fmt.Sprint(len(ids))), nil
+}
+
+//==============================================================================================================================
+//     dispatchOnce - Run the invoke unless the request ID already has a result, in which case return that. Reusing a
+//                    request ID for a different call is a conflict
+//==============================================================================================================================
+func (t *SimpleChaincode) dispatchOnce(stub *shim.ChaincodeStub, spec FunctionSpec, args []string, parsed Args) ([]byte, error) {
+    requestID := parsed.String(REQUEST_ID_ARG)
+    fingerprint := getMd5Hash(spec.Name + "\x00" + strings.Join(args[:len(args) - 1], "\x00"))
+
+    record, found, err := getRequestRecord(stub, requestID)
+    if checkErrors(err){return nil, err}
+    if found && record.Fingerprint != fingerprint {return nil, newError(ERR_CONFLICT, "Request " + requestID + " was already used for a different call")}
+    if found {
+        log.info("Replayed request", "requestID", requestID, "originalTxID", record.TxID)
+        if record.Result == "" {return nil, nil}
+        return []byte(record.Result), nil
+    }
+
+    result, err := spec.handler(t, stub, parsed)
+    if checkErrors(err){return nil, err}
+
+    //an expired record is replaced
+    var stored RequestRecord
+    err = requestRecords.get(stub, requestID, &stored)
+    if err == nil {err = requestRecords.delete(stub, &stored)}
+    if checkErrors(err) && !isNotFound(err){return nil, err}
+
+    record = RequestRecord{ID: requestID, Function: spec.Name, Fingerprint: fingerprint, Result: string(result), TxID: getTxID(stub)}
+    record.Created, err = getTxTime(stub)
+    if checkErrors(err){return nil, err}
+    if config.RequestRetention > 0 {record.Expires = record.Created + config.RequestRetention}
+
+    err = requestRecords.create(stub, &record)
+    if checkErrors(err){return nil, err}
+
+    return result, nil
+}
+
+//==============================================================================================================================
+//     CRUD Subroutines
+//==============================================================================================================================
+//     getRequestRecord - The unexpired record for the request ID
+//==============================================================================================================================
+func getRequestRecord(stub *shim.ChaincodeStub, requestID string) (RequestRecord, bool, error) {
+    var object RequestRecord
+    if requestID == "" {return object, false, newError(ERR_INVALID_ARGUMENT, "A request ID is required")}
+
+    err := requestRecords.get(stub, requestID, &object)
+    if isNotFound(err) {return object, false, nil}
+    if checkErrors(err){return object, false, err}
+
+    now, err := getTxTime(stub)
+    if checkErrors(err){return object, false, err}
+    if object.Expires > 0 && object.Expires <= now {return object, false, nil}
+
+    return object, true, nil
+}
+
+func (object *RequestRecord) getID() string {return object.ID}
+func (object *RequestRecord) getVersion() int {return object.Version}
+func (object *RequestRecord) setVersion(version int) {object.Version = version}
+
+func (object *RequestRecord) indexes() map[string][]string {
+    if object.Expires == 0 {return map[string][]string{}}
+    return map[string][]string{"expires": {indexValue(fmt.Sprintf("%019d", object.Expires))}}
+}
+
+//==============================================================================================================================
+//     Parsing Subroutines
+//==============================================================================================================================
+func (object *RequestRecord) marshal() ([]byte, error) {
+    bytes, err := json.Marshal(object)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling request record", err)}
+    return bytes, nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strconv"
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//    AccountLimits - Caps on a single account, zero means unlimited. MaxHoldingPct is a percentage of the property's
+//                    units, counting units held, escrowed in sells and bid for in open buys. An account's own limits
+//                    replace the limits for its role
+//==============================================================================================================================
+type AccountLimits struct {
+    MaxHoldingPct       float64     `json:"maxHoldingPct"`
+    MaxOpenNotional     float64     `json:"maxOpenNotional"`
+    MaxDailyWithdrawal  float64     `json:"maxDailyWithdrawal"`
+}
+
+//==============================================================================================================================
+//    LimitUsage - What getLimitUsage returns. Headroom is left out when the limit is unlimited
+//==============================================================================================================================
+type LimitUsage struct {
+    AccountID           string          `json:"accountID"`
+    Limits              AccountLimits   `json:"limits"`
+    Holdings            []HoldingUsage  `json:"holdings"`
+    OpenNotional        float64         `json:"openNotional"`
+    OpenNotionalHeadroom *float64       `json:"openNotionalHeadroom,omitempty"`
+    DailyWithdrawn      float64         `json:"dailyWithdrawn"`
+    DailyWithdrawalHeadroom *float64    `json:"dailyWithdrawalHeadroom,omitempty"`
+}
+
+type HoldingUsage struct {
+    PropertyID          string      `json:"propertyID"`
+    Units               int         `json:"units"`
+    PropertyUnits       int         `json:"propertyUnits"`
+    Pct                 float64     `json:"pct"`
+    HeadroomUnits       *int        `json:"headroomUnits,omitempty"`
+}
+
+//==============================================================================================================================
+//     Query Logic Methods
+//==============================================================================================================================
+//     getLimitUsage - The account's limits, how much of each it is using and the headroom left
+//==============================================================================================================================
+func (t *SimpleChaincode) getLimitUsage(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    account, err := getAccount(stub, args.String("accountID"))
+    if checkErrors(err){return nil, err}
+
+    var usage LimitUsage
+    usage.AccountID = account.ID
+    usage.Limits = getAccountLimits(account)
+    usage.Holdings = []HoldingUsage{}
+
+    exposure, err := getUnitExposure(stub, account)
+    if checkErrors(err){return nil, err}
+    for _, propertyID := range sortedKeys(exposure) {
+        property, err := getProperty(stub, propertyID)
+        if checkErrors(err){return nil, err}
+
+        holding := HoldingUsage{PropertyID: propertyID, Units: exposure[propertyID], PropertyUnits: property.Units}
+        if property.Units > 0 {holding.Pct = 100 * float64(holding.Units) / float64(property.Units)}
+        if usage.Limits.MaxHoldingPct > 0 {
+            headroom := maxHoldingUnits(usage.Limits, property) - holding.Units
+            holding.HeadroomUnits = &headroom
+        }
+        usage.Holdings = append(usage.Holdings, holding)
+    }
+
+    usage.OpenNotional, err = getOpenNotional(stub, account)
+    if checkErrors(err){return nil, err}
+    if usage.Limits.MaxOpenNotional > 0 {
+        headroom := usage.Limits.MaxOpenNotional - usage.OpenNotional
+        usage.OpenNotionalHeadroom = &headroom
+    }
+
+    usage.DailyWithdrawn, err = getDailyWithdrawn(stub, account)
+    if checkErrors(err){return nil, err}
+    if usage.Limits.MaxDailyWithdrawal > 0 {
+        headroom := usage.Limits.MaxDailyWithdrawal - usage.DailyWithdrawn
+        usage.DailyWithdrawalHeadroom = &headroom
+    }
+
+    bytes, err := json.Marshal(usage)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling limit usage", err)}
+    return bytes, nil
+}
+
+//==============================================================================================================================
+//     Invoke Logic Methods
+//==============================================================================================================================
+//     setAccountLimits - Give an account its own limits in place of its role's. The registry makes sure only an admin can
+//                        do this. Passing no limits goes back to the role's
+//==============================================================================================================================
+func (t *SimpleChaincode) setAccountLimits(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    account, err := getAccount(stub, args.String("accountID"))
+    if checkErrors(err){return nil, err}
+
+    account.Limits = nil
+    if args.Has("limits") {
+        limits := *args.Body("limits").(*AccountLimits)
+        err = limits.validate()
+        if checkErrors(err){return nil, err}
+        account.Limits = &limits
+    }
+
+    err = account.save(stub)
+    if checkErrors(err){return nil, err}
+
+    log.info("Set account limits", "accountID", account.ID)
+    return nil, nil
+}
+
+//==============================================================================================================================
+//     Limit Checks
+//==============================================================================================================================
+//     getAccountLimits - The account's own limits, or its role's
+//==============================================================================================================================
+func getAccountLimits(account Account) AccountLimits {
+    if account.Limits != nil {return *account.Limits}
+    return config.RoleLimits[strconv.Itoa(account.Role)]
+}
+
+func (object *AccountLimits) validate() error {
+    if object.MaxHoldingPct < 0 || object.MaxHoldingPct > 100 {return newError(ERR_INVALID_ARGUMENT, "Maximum holding must be between 0 and 100 percent")}
+    if object.MaxOpenNotional < 0 || object.MaxDailyWithdrawal < 0 {return newError(ERR_INVALID_ARGUMENT, "Limits can't be negative")}
+    return nil
+}
+
+//checkHoldingLimit - whether the account can take on units more of the property
+func checkHoldingLimit(stub *shim.ChaincodeStub, account Account, propertyID string, units int) error {
+    limits := getAccountLimits(account)
+    if limits.MaxHoldingPct == 0 {return nil}
+
+    property, err := getProperty(stub, propertyID)
+    if checkErrors(err){return err}
+
+    exposure, err := getUnitExposure(stub, account)
+    if checkErrors(err){return err}
+
+    if exposure[propertyID] + units > maxHoldingUnits(limits, property) {
+        return newError(ERR_STATE_VIOLATION, "Account " + account.ID + " would hold more than " + fmt.Sprint(limits.MaxHoldingPct) + "% of property " + propertyID)
+    }
+    return nil
+}
+
+//checkOpenNotionalLimit - whether the account can have notional more in open trades
+func checkOpenNotionalLimit(stub *shim.ChaincodeStub, account Account, notional float64) error {
+    limits := getAccountLimits(account)
+    if limits.MaxOpenNotional == 0 {return nil}
+
+    open, err := getOpenNotional(stub, account)
+    if checkErrors(err){return err}
+
+    if open + notional > limits.MaxOpenNotional {return newError(ERR_STATE_VIOLATION, "Account " + account.ID + " would exceed its open trade limit")}
+    return nil
+}
+
+//checkWithdrawalLimit - whether the account can withdraw amount more today
+func checkWithdrawalLimit(stub *shim.ChaincodeStub, account Account, amount float64) error {
+    limits := getAccountLimits(account)
+    if limits.MaxDailyWithdrawal == 0 {return nil}
+
+    withdrawn, err := getDailyWithdrawn(stub, account)
+    if checkErrors(err){return err}
+
+    if withdrawn + amount > limits.MaxDailyWithdrawal {return newError(ERR_STATE_VIOLATION, "Account " + account.ID + " would exceed its daily withdrawal limit")}
+    return nil
+}
+
+func maxHoldingUnits(limits AccountLimits, property Property) int {
+    return int(float64(property.Units) * limits.MaxHoldingPct / 100)
+}
+
+//==============================================================================================================================
+//     Usage Subroutines
+//==============================================================================================================================
+//     getUnitExposure - Units of each property held, escrowed in open sells or bid for in open buys
+//==============================================================================================================================
+func getUnitExposure(stub *shim.ChaincodeStub, account Account) (map[string]int, error) {
+    exposure := map[string]int{}
+    for i := 0; i < len(account.Holdings); i++ {
+        exposure[account.Holdings[i].Entity] += account.Holdings[i].Units
+    }
+
+    open, err := account.getTrades(stub)
+    if checkErrors(err){return nil, err}
+    for i := 0; i < len(open); i++ {
+        //dormant sells have escrowed their units too
+        exposure[open[i].PropertyID] += open[i].Units
+    }
+
+    return exposure, nil
+}
+
+//getOpenNotional - the value of the account's open and dormant trades, stops that will trade at market are valued
+//                  at their stop price
+func getOpenNotional(stub *shim.ChaincodeStub, account Account) (float64, error) {
+    open, err := account.getTrades(stub)
+    if checkErrors(err){return 0, err}
+
+    var notional float64
+    for i := 0; i < len(open); i++ {
+        notional += open[i].orderPrice() * float64(open[i].Units)
+    }
+    return notional, nil
+}
+
+func sortedKeys(values map[string]int) []string {
+    keys := []string{}
+    for key := range values {keys = append(keys, key)}
+    sort.Strings(keys)
+    return keys
+}
+
+//getDailyWithdrawn - cash withdrawn since midnight UTC of the transaction, plus withdrawals still waiting on the exchange
+func getDailyWithdrawn(stub *shim.ChaincodeStub, account Account) (float64, error) {
+    now, err := getTxTime(stub)
+    if checkErrors(err){return 0, err}
+    start := now - now % 86400
+
+    ids, err := ledgerEntries.listBetween(stub, "account", indexValue(account.ID, fmt.Sprintf("%019d", start)), indexValue(account.ID, fmt.Sprintf("%019d", start + 86400)))
+    if checkErrors(err){return 0, err}
+
+    var withdrawn float64
+    for i := 0; i < len(ids); i++ {
+        var entry LedgerEntry
+        err = ledgerEntries.get(stub, ids[i], &entry)
+        if checkErrors(err){return 0, err}
+        if entry.Type == LEDGER_WITHDRAWAL {withdrawn -= entry.Amount}
+    }
+
+    requests, err := getIndexedCashRequests(stub, "account", account.ID)
+    if checkErrors(err){return 0, err}
+    for i := 0; i < len(requests); i++ {
+        if requests[i].Type == LEDGER_WITHDRAWAL && requests[i].Status == CASH_REQUEST_PENDING {withdrawn += requests[i].Amount}
+    }
+    return withdrawn, nil
+}
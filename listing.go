@@ -0,0 +1,110 @@
+package main
+
+import (
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const   PAGE_SIZE_DEFAULT   = 50
+const   PAGE_SIZE_MAX       = 500
+
+//==============================================================================================================================
+//    Page - One page of a listing in ID order. Pass Bookmark back to get the next page, it is left out on the last one
+//==============================================================================================================================
+type Page struct {
+    Records         interface{} `json:"records"`
+    Bookmark        string      `json:"bookmark,omitempty"`
+}
+
+//==============================================================================================================================
+//     Query Logic Methods
+//==============================================================================================================================
+//     listAccounts - Optionally only accounts with the given status
+//==============================================================================================================================
+func (t *SimpleChaincode) listAccounts(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    pageSize, err := getPageSize(args)
+    if checkErrors(err){return nil, err}
+
+    objects := []Account{}
+    bookmark, err := accounts.page(stub, args.String("bookmark"), pageSize, func(bytes []byte) (bool, error) {
+        var object Account
+        err := json.Unmarshal(bytes, &object)
+        if checkErrors(err){return false, wrapError(ERR_INTERNAL, "Error unmarshalling account", err)}
+        if args.Has("status") && object.Status != args.Int("status") {return false, nil}
+
+        objects = append(objects, object)
+        return true, nil
+    })
+    if checkErrors(err){return nil, err}
+
+    return marshalPage(objects, bookmark)
+}
+
+//==============================================================================================================================
+//     listProperties - Optionally only properties with the given status
+//==============================================================================================================================
+func (t *SimpleChaincode) listProperties(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    pageSize, err := getPageSize(args)
+    if checkErrors(err){return nil, err}
+
+    objects := []Property{}
+    bookmark, err := properties.page(stub, args.String("bookmark"), pageSize, func(bytes []byte) (bool, error) {
+        var object Property
+        err := json.Unmarshal(bytes, &object)
+        if checkErrors(err){return false, wrapError(ERR_INTERNAL, "Error unmarshalling property", err)}
+        if args.Has("status") && object.Status != args.Int("status") {return false, nil}
+
+        objects = append(objects, object)
+        return true, nil
+    })
+    if checkErrors(err){return nil, err}
+
+    return marshalPage(objects, bookmark)
+}
+
+//==============================================================================================================================
+//     listTrades - Every trade on the book, optionally only those with the given direction or status. Filled, cancelled
+//                  and expired trades are removed from the book, so only the open and dormant statuses can be asked for
+//==============================================================================================================================
+func (t *SimpleChaincode) listTrades(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    pageSize, err := getPageSize(args)
+    if checkErrors(err){return nil, err}
+
+    direction := args.String("direction")
+    if direction != "" && direction != TRADE_BUY && direction != TRADE_SELL {return nil, newError(ERR_INVALID_ARGUMENT, "Invalid trade direction " + direction)}
+    if args.Has("status") && args.Int("status") != TRADE_STATE_OPEN && args.Int("status") != TRADE_STATE_DORMANT {
+        return nil, newError(ERR_INVALID_ARGUMENT, "Only open and dormant trades are kept on the book")
+    }
+
+    objects := []Trade{}
+    bookmark, err := trades.page(stub, args.String("bookmark"), pageSize, func(bytes []byte) (bool, error) {
+        var object Trade
+        err := json.Unmarshal(bytes, &object)
+        if checkErrors(err){return false, wrapError(ERR_INTERNAL, "Error unmarshalling trade", err)}
+        if direction != "" && object.Direction != direction {return false, nil}
+        if args.Has("status") && object.Status != args.Int("status") {return false, nil}
+
+        objects = append(objects, object)
+        return true, nil
+    })
+    if checkErrors(err){return nil, err}
+
+    return marshalPage(objects, bookmark)
+}
+
+//==============================================================================================================================
+//     Paging Subroutines
+//==============================================================================================================================
+func getPageSize(args Args) (int, error) {
+    if !args.Has("pageSize") {return PAGE_SIZE_DEFAULT, nil}
+
+    pageSize := args.Int("pageSize")
+    if pageSize <= 0 || pageSize > PAGE_SIZE_MAX {return 0, newError(ERR_INVALID_ARGUMENT, "Page size must be between 1 and 500")}
+    return pageSize, nil
+}
+
+func marshalPage(records interface{}, bookmark string) ([]byte, error) {
+    bytes, err := json.Marshal(Page{Records: records, Bookmark: bookmark})
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling page", err)}
+    return bytes, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+    "fmt"
+    "path/filepath"
+    "runtime"
+    "time"
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//    Log - Writes one JSON line per entry carrying the transaction and chaincode function being run. The level comes
+//          from the persisted configuration
+//==============================================================================================================================
+type Log struct {
+    txID            string
+    function        string
+    started         time.Time
+}
+
+var logLevelNames = map[int]string{LOG_DEBUG: "DEBUG", LOG_INFO: "INFO", LOG_WARN: "WARN", LOG_ERROR: "ERROR"}
+
+//fields whose values are only written at debug level
+var redactedFields = map[string]bool{"cash": true, "balance": true, "escrow": true, "amount": true, "fee": true, "cert": true, "ecert": true}
+
+//==============================================================================================================================
+//     begin / end - Bracket a chaincode call so every entry in between carries its context and elapsed time
+//==============================================================================================================================
+func (l *Log) begin(stub *shim.ChaincodeStub, function string) {
+    l.txID = getTxID(stub)
+    l.function = function
+    l.started = time.Now()
+    l.debug("started")
+}
+
+func (l *Log) end(err error) {
+    if checkErrors(err) {
+        l.error("failed", "error", err.Error())
+    } else {
+        l.debug("completed")
+    }
+}
+
+//==============================================================================================================================
+//     Logging - fields are alternating keys and values
+//==============================================================================================================================
+func (l *Log) debug(text string, fields ...interface{}) {
+    l.log(LOG_DEBUG, text, fields)
+}
+
+func (l *Log) info(text string, fields ...interface{}) {
+    l.log(LOG_INFO, text, fields)
+}
+
+func (l *Log) warn(text string, fields ...interface{}) {
+    l.log(LOG_WARN, text, fields)
+}
+
+func (l *Log) error(text string, fields ...interface{}) {
+    l.log(LOG_ERROR, text, fields)
+}
+
+func (l *Log) log(logLevel int, text string, fields []interface{}) {
+    if !l.shouldLog(logLevel) {return}
+
+    entry := map[string]interface{}{}
+    for i := 0; i + 1 < len(fields); i += 2 {
+        key := fmt.Sprint(fields[i])
+        if logLevel >= LOG_INFO && redactedFields[key] {
+            entry[key] = "[REDACTED]"
+        } else {
+            entry[key] = fields[i+1]
+        }
+    }
+
+    entry["level"] = logLevelNames[logLevel]
+    entry["msg"] = text
+    entry["txID"] = l.txID
+    entry["function"] = l.function
+    if !l.started.IsZero() {entry["elapsed"] = time.Since(l.started).String()}
+
+    //skip log and the level method to find who logged
+    _, file, line, ok := runtime.Caller(2)
+    if ok {entry["caller"] = filepath.Base(file) + ":" + fmt.Sprint(line)}
+
+    bytes, err := json.Marshal(entry)
+    if checkErrors(err) {
+        fmt.Println(logLevelNames[logLevel] + ": " + text)
+        return
+    }
+    fmt.Println(string(bytes))
+}
+
+func (l *Log) shouldLog(logLevel int) bool {
+    return logLevel >= config.LogLevel
+}
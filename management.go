@@ -0,0 +1,217 @@
+package main
+
+import (
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const   EXPENSE_MANAGEMENT  = "MANAGEMENT"
+
+//==============================================================================================================================
+//    ManagementAgreement - Who manages a property and for what fee. The fee is FeeBps of the rent received from the
+//                          start date. It accrues as rent comes in and is paid from the operating account ahead of each
+//                          distribution
+//==============================================================================================================================
+type ManagementAgreement struct {
+    ManagerID       string      `json:"managerID"`
+    FeeBps          int         `json:"feeBps"`
+    StartDate       int64       `json:"startDate"`
+    Accrued         float64     `json:"accrued"`
+}
+
+//==============================================================================================================================
+//    ManagerNomination - A replacement manager put forward by a holder. The holders vote FOR or AGAINST on the proposal
+//                        and when it closes the nominee takes over if the FOR votes hold more than half the units
+//==============================================================================================================================
+type ManagerNomination struct {
+    ManagerID       string      `json:"managerID"`
+    FeeBps          int         `json:"feeBps"`
+    StartDate       int64       `json:"startDate"`
+    NominatedBy     string      `json:"nominatedBy"`
+    ProposalID      string      `json:"proposalID"`
+}
+
+const   NOMINATION_FOR      = "FOR"
+const   NOMINATION_AGAINST  = "AGAINST"
+
+//==============================================================================================================================
+//     Query Logic Methods
+//==============================================================================================================================
+//     getManagedProperties - Every property the account manages
+//==============================================================================================================================
+func (t *SimpleChaincode) getManagedProperties(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    ids, err := properties.list(stub, "manager", args.String("managerID"))
+    if checkErrors(err){return nil, err}
+
+    objects := []Property{}
+    for i := 0; i < len(ids); i++ {
+        property, err := getProperty(stub, ids[i])
+        if checkErrors(err){return nil, err}
+        objects = append(objects, property)
+    }
+    return marshalProperties(objects)
+}
+
+//==============================================================================================================================
+//     Invoke Logic Methods
+//==============================================================================================================================
+//     assignManager - The issuer appoints the first manager. After that only the holders can replace them
+//==============================================================================================================================
+func (t *SimpleChaincode) assignManager(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    property, err := getProperty(stub, args.String("propertyID"))
+    if checkErrors(err){return nil, err}
+    if property.closed() {return nil, newError(ERR_STATE_VIOLATION, "Property " + property.ID + " is no longer trading")}
+    if args.String("issuerID") != property.Issuer {return nil, newError(ERR_UNAUTHORISED, "Only the issuer can assign a manager to property " + property.ID)}
+    if property.ManagedBy != "" {return nil, newError(ERR_CONFLICT, "Property " + property.ID + " already has a manager, the holders must vote to replace them")}
+
+    agreement, err := newManagementAgreement(stub, args.String("managerAccountID"), args.Int("feeBps"), int64(args.Int("startDate")))
+    if checkErrors(err){return nil, err}
+
+    property.appoint(agreement)
+    err = property.save(stub)
+    if checkErrors(err){return nil, err}
+
+    log.info("Assigned manager", "propertyID", property.ID, "managerID", agreement.ManagerID, "feeBps", agreement.FeeBps)
+    return property.marshal()
+}
+
+//==============================================================================================================================
+//     nominateManager - A holder puts forward a replacement manager. The nomination is a proposal the holders of record
+//                       vote FOR or AGAINST with castVote until closesAt, and the nominator votes FOR. There is one
+//                       nomination at a time, so a new one waits until the last has been closed
+//==============================================================================================================================
+func (t *SimpleChaincode) nominateManager(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    holderID := args.String("holderID")
+
+    property, err := getProperty(stub, args.String("propertyID"))
+    if checkErrors(err){return nil, err}
+    if property.Nomination != nil {return nil, newError(ERR_CONFLICT, "The vote on " + property.Nomination.ManagerID + " for property " + property.ID + " hasn't closed yet")}
+
+    agreement, err := newManagementAgreement(stub, args.String("managerAccountID"), args.Int("feeBps"), int64(args.Int("startDate")))
+    if checkErrors(err){return nil, err}
+    if agreement.ManagerID == property.ManagedBy {return nil, newError(ERR_CONFLICT, agreement.ManagerID + " already manages property " + property.ID)}
+
+    proposal := Proposal{PropertyID: property.ID, ProposedBy: holderID, SnapshotID: args.String("snapshotID"), ClosesAt: int64(args.Int("closesAt")),
+        Description: "Replace the manager of property " + property.ID + " with " + agreement.ManagerID, Options: []string{NOMINATION_FOR, NOMINATION_AGAINST}}
+    property, err = proposal.open(stub)
+    if checkErrors(err){return nil, err}
+    if proposal.Snapshot[holderID] <= 0 {return nil, newError(ERR_UNAUTHORISED, "Only holders of property " + property.ID + " can nominate a manager")}
+
+    proposal.Votes[holderID] = NOMINATION_FOR
+    err = proposals.save(stub, &proposal)
+    if checkErrors(err){return nil, err}
+
+    property.Nomination = &ManagerNomination{ManagerID: agreement.ManagerID, FeeBps: agreement.FeeBps, StartDate: agreement.StartDate, NominatedBy: holderID, ProposalID: proposal.ID}
+    err = property.save(stub)
+    if checkErrors(err){return nil, err}
+
+    log.info("Nominated manager", "propertyID", property.ID, "managerID", agreement.ManagerID, "proposalID", proposal.ID)
+    return property.marshal()
+}
+
+//==============================================================================================================================
+//     payManagementFee - Pay the manager the fee accrued so far, as far as the operating account's cash covers it
+//==============================================================================================================================
+func (t *SimpleChaincode) payManagementFee(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    property, _, err := getManagedProperty(stub, args.String("managerID"), args.String("propertyID"))
+    if checkErrors(err){return nil, err}
+
+    err = property.settleManagementFee(stub)
+    if checkErrors(err){return nil, err}
+    err = property.save(stub)
+    if checkErrors(err){return nil, err}
+
+    return property.marshal()
+}
+
+//==============================================================================================================================
+//     Management Subroutines
+//==============================================================================================================================
+func newManagementAgreement(stub *shim.ChaincodeStub, managerID string, feeBps int, startDate int64) (ManagementAgreement, error) {
+    agreement := ManagementAgreement{ManagerID: managerID, FeeBps: feeBps, StartDate: startDate}
+    if feeBps < 0 || feeBps > 10000 {return agreement, newError(ERR_INVALID_ARGUMENT, "Management fee must be between 0 and 10000 basis points")}
+
+    _, err := checkAccountRole(stub, managerID, ROLE_MANAGER)
+    if checkErrors(err){return agreement, err}
+
+    if agreement.StartDate == 0 {
+        agreement.StartDate, err = getTxTime(stub)
+        if checkErrors(err){return agreement, err}
+    }
+    return agreement, nil
+}
+
+func (object *Property) appoint(agreement ManagementAgreement) {
+    object.ManagedBy = agreement.ManagerID
+    object.Management = &agreement
+    object.Nomination = nil
+}
+
+//resolveNomination - once the vote on a nomination closes, appoint the nominee if more than half the units of record
+//                    voted FOR, otherwise drop the nomination. Other proposals are left alone
+func resolveNomination(stub *shim.ChaincodeStub, proposal Proposal) error {
+    property, err := getProperty(stub, proposal.PropertyID)
+    if checkErrors(err){return err}
+    nomination := property.Nomination
+    if nomination == nil || nomination.ProposalID != proposal.ID {return nil}
+
+    if proposal.Tally[NOMINATION_FOR] * 2 > proposal.Units && !property.closed() {
+        err = property.settleManagementFee(stub)
+        if checkErrors(err){return err}
+
+        log.info("Replaced manager", "propertyID", property.ID, "from", property.ManagedBy, "to", nomination.ManagerID, "units", proposal.Tally[NOMINATION_FOR])
+        property.appoint(ManagementAgreement{ManagerID: nomination.ManagerID, FeeBps: nomination.FeeBps, StartDate: nomination.StartDate})
+    } else {
+        log.info("Rejected manager nomination", "propertyID", property.ID, "managerID", nomination.ManagerID, "units", proposal.Tally[NOMINATION_FOR])
+        property.Nomination = nil
+    }
+    return property.save(stub)
+}
+
+//accrueManagementFee - the manager's share of rent received once the agreement has started, recorded as an expense
+func (object *Property) accrueManagementFee(stub *shim.ChaincodeStub, rent float64) error {
+    agreement := object.Management
+    if agreement == nil || agreement.FeeBps == 0 {return nil}
+
+    now, err := getTxTime(stub)
+    if checkErrors(err){return err}
+    if now < agreement.StartDate {return nil}
+
+    fee := roundCents(rent * float64(agreement.FeeBps) / 10000)
+    if fee == 0 {return nil}
+    agreement.Accrued = roundCents(agreement.Accrued + fee)
+
+    entry := OperatingEntry{PropertyID: object.ID, Type: OPERATING_EXPENSE, Category: EXPENSE_MANAGEMENT, Amount: fee, RecordedBy: agreement.ManagerID}
+    return entry.post(stub, LEDGER_MANAGEMENT_FEE, 0)
+}
+
+//settleManagementFee - pay the accrued fee out of the operating account. Whatever the cash doesn't cover stays accrued
+func (object *Property) settleManagementFee(stub *shim.ChaincodeStub) error {
+    agreement := object.Management
+    if agreement == nil || agreement.Accrued == 0 {return nil}
+
+    propertyAccount, err := getAccount(stub, object.ID)
+    if checkErrors(err){return err}
+
+    fee := agreement.Accrued
+    if propertyAccount.Cash < fee {fee = propertyAccount.Cash}
+    if fee <= 0 {return nil}
+
+    manager, err := getAccount(stub, agreement.ManagerID)
+    if checkErrors(err){return err}
+
+    propertyAccount.Cash = roundCents(propertyAccount.Cash - fee)
+    err = propertyAccount.save(stub)
+    if checkErrors(err){return err}
+    err = postLedgerEntry(stub, propertyAccount, LEDGER_MANAGEMENT_FEE, -fee, 0, manager.ID)
+    if checkErrors(err){return err}
+
+    manager.Cash += fee
+    err = manager.save(stub)
+    if checkErrors(err){return err}
+    err = postLedgerEntry(stub, manager, LEDGER_MANAGEMENT_FEE, fee, 0, object.ID)
+    if checkErrors(err){return err}
+
+    agreement.Accrued = roundCents(agreement.Accrued - fee)
+    log.info("Paid management fee", "propertyID", object.ID, "managerID", manager.ID, "fee", fee, "accrued", agreement.Accrued)
+    return nil
+}
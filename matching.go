@@ -0,0 +1,430 @@
+package main
+
+import (
+    "sort"
+    "strconv"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//    priceTimeOrder - Sorts resting trades best price first, then oldest first
+//==============================================================================================================================
+type priceTimeOrder []Trade
+
+func (a priceTimeOrder) Len() int      { return len(a) }
+func (a priceTimeOrder) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a priceTimeOrder) Less(i, j int) bool {
+    if a[i].Price != a[j].Price {
+        if a[i].Direction == TRADE_BUY {return a[i].Price > a[j].Price}
+        return a[i].Price < a[j].Price
+    }
+    if a[i].Created != a[j].Created {return a[i].Created < a[j].Created}
+    return a[i].ID < a[j].ID
+}
+
+//==============================================================================================================================
+//    createdOrder - Sorts dormant stops oldest first so they trigger in the order they were placed
+//==============================================================================================================================
+type createdOrder []Trade
+
+func (a createdOrder) Len() int      { return len(a) }
+func (a createdOrder) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a createdOrder) Less(i, j int) bool {
+    if a[i].Created != a[j].Created {return a[i].Created < a[j].Created}
+    return a[i].ID < a[j].ID
+}
+
+//==============================================================================================================================
+//     matchTrade - Match a trade against the resting trades for its property. Fills happen at the resting trade's
+//                  price, the incoming trade pays the taker fee and the resting trade the maker fee. What isn't filled
+//                  rests on the book if the time in force allows, otherwise it is cancelled. Reaching one of the
+//                  account's own trades cancels the newest, oldest or both depending on the self trade prevention
+//                  mode. A stop that hasn't triggered is saved as it is. Any fills can trigger stops, which are
+//                  matched in turn. Outside continuous trading the trade rests without matching
+//==============================================================================================================================
+func matchTrade(stub *shim.ChaincodeStub, trade *Trade) ([]Execution, error) {
+    var executions []Execution
+    stored := trade.Version > 0
+
+    now, err := getTxTime(stub)
+    if checkErrors(err){return nil, err}
+
+    //before the open trades rest for the auction
+    market, err := getMarket(stub)
+    if checkErrors(err){return nil, err}
+    if market.State != SESSION_CONTINUOUS {return nil, trade.save(stub)}
+
+    if trade.Status == TRADE_STATE_DORMANT {
+        property, err := getProperty(stub, trade.PropertyID)
+        if checkErrors(err){return nil, err}
+        if !trade.stopTriggered(property.LastPrice) {return nil, trade.save(stub)}
+
+        log.info("Triggered stop", "tradeID", trade.ID, "lastPrice", property.LastPrice)
+        trade.Status = TRADE_STATE_OPEN
+    }
+
+    book, err := getBook(stub, trade, now)
+    if checkErrors(err){return nil, err}
+
+    prevention := trade.SelfTradePrevention
+    if prevention == "" {prevention = config.SelfTradePrevention}
+
+    //the account's own trades never fill so they don't count towards filling or killing, and unless only the oldest
+    //is cancelled matching stops at the first of them so nothing behind it counts either
+    if trade.TimeInForce == TIF_FOK {
+        var available int
+        for i := 0; i < len(book); i++ {
+            if book[i].AccountID != trade.AccountID {
+                available += book[i].Units
+            } else if prevention != STP_CANCEL_OLDEST {
+                break
+            }
+        }
+        if available < trade.Units {return nil, trade.cancel(stub, TRADE_STATE_CANCELLED)}
+    }
+
+    schedule := config.Fees
+    var role int
+    marketBuy := trade.marketable() && trade.Direction == TRADE_BUY
+    if marketBuy {
+        role, err = escrowMarketBuy(stub, schedule, trade, book, prevention)
+        if checkErrors(err) && stored && errorCode(err) != ERR_INTERNAL {
+            //a stop can't fail the trade that triggered it
+            log.warn("Cancelled stop that couldn't be funded", "tradeID", trade.ID, "error", err.Error())
+            return nil, trade.cancel(stub, TRADE_STATE_CANCELLED)
+        }
+        if checkErrors(err){return nil, err}
+    }
+
+    selfTraded := false
+    for i := 0; i < len(book) && trade.Units > 0 && !selfTraded; i++ {
+        resting := book[i]
+
+        if resting.AccountID == trade.AccountID {
+            log.info("Prevented self trade", "tradeID", trade.ID, "restingTradeID", resting.ID, "mode", prevention)
+            if prevention != STP_CANCEL_NEWEST {
+                err = resting.cancel(stub, TRADE_STATE_CANCELLED)
+                if checkErrors(err){return nil, err}
+            }
+            selfTraded = prevention != STP_CANCEL_OLDEST
+            continue
+        }
+
+        units := resting.Units
+        if trade.Units < units {units = trade.Units}
+
+        buy, sell := trade, &resting
+        if trade.Direction == TRADE_SELL {buy, sell = &resting, trade}
+
+        var execution Execution
+        execution.ID = getMd5Hash(getTxID(stub) + buy.ID + sell.ID)
+        execution.PropertyID = trade.PropertyID
+        execution.BuyTradeID = buy.ID
+        execution.SellTradeID = sell.ID
+        execution.BuyerID = buy.AccountID
+        execution.SellerID = sell.AccountID
+        execution.Price = resting.Price
+        execution.Units = units
+        execution.Timestamp = now
+
+        //release the share of the buyer's escrow that covers this fill. A market buy escrowed each fill separately
+        reserved := buy.Escrow
+        if units < buy.Units && marketBuy {
+            notional := roundCents(resting.Price * float64(units))
+            reserved = notional + schedule.maxTradeFee(notional, role)
+            if reserved > buy.Escrow {reserved = buy.Escrow}
+        } else if units < buy.Units {
+            reserved = buy.Escrow * float64(units) / float64(buy.Units)
+        }
+        buy.Escrow -= reserved
+        buy.Units -= units
+        sell.Units -= units
+
+        err = settle(stub, schedule, &execution, reserved, true, buy == &resting, sell == &resting)
+        if checkErrors(err){return nil, err}
+
+        if resting.Units == 0 {
+            err = resting.remove(stub)
+        } else {
+            err = resting.save(stub)
+        }
+        if checkErrors(err){return nil, err}
+
+        executions = append(executions, execution)
+    }
+
+    switch {
+        case trade.Units == 0:
+            trade.Status = TRADE_STATE_FILLED
+            if stored {err = trade.remove(stub)}
+        case selfTraded || trade.marketable() || trade.TimeInForce == TIF_IOC || trade.TimeInForce == TIF_FOK:
+            err = trade.cancel(stub, TRADE_STATE_CANCELLED)
+        default:
+            err = trade.save(stub)
+    }
+    if checkErrors(err){return nil, err}
+
+    if len(executions) > 0 {
+        triggered, err := triggerStops(stub, trade.PropertyID)
+        if checkErrors(err){return nil, err}
+        executions = append(executions, triggered...)
+    }
+
+    return executions, nil
+}
+
+func validSelfTradePrevention(mode string) bool {
+    return mode == STP_CANCEL_NEWEST || mode == STP_CANCEL_OLDEST || mode == STP_CANCEL_BOTH
+}
+
+//==============================================================================================================================
+//     getBook - The open trades the trade crosses, best first. Expired trades found on the way are cancelled
+//==============================================================================================================================
+func getBook(stub *shim.ChaincodeStub, trade *Trade, now int64) ([]Trade, error) {
+    resting, err := getPropertyTrades(stub, trade.PropertyID)
+    if checkErrors(err){return nil, err}
+
+    var book []Trade
+    for i := 0; i < len(resting); i++ {
+        other := resting[i]
+        if other.ID == trade.ID || other.Status != TRADE_STATE_OPEN {continue}
+
+        if other.expired(now) {
+            err = other.cancel(stub, TRADE_STATE_EXPIRED)
+            if checkErrors(err){return nil, err}
+            continue
+        }
+        if trade.crosses(other) {book = append(book, other)}
+    }
+    sort.Sort(priceTimeOrder(book))
+
+    return book, nil
+}
+
+//==============================================================================================================================
+//     escrowMarketBuy - Escrow what it would cost to walk the book for the trade's units, fill by fill, plus the highest
+//                       fee we could charge on each. The walk skips the account's own trades and stops at the first of
+//                       them unless only the oldest is cancelled, the same as matching. It is held to the maximum trade
+//                       value as well, since the trade was only valued at the reference price. Returns the buyer's role
+//                       for pricing the fees
+//==============================================================================================================================
+func escrowMarketBuy(stub *shim.ChaincodeStub, schedule FeeSchedule, trade *Trade, book []Trade, prevention string) (int, error) {
+    account, err := getAccount(stub, trade.AccountID)
+    if checkErrors(err){return 0, err}
+
+    units := trade.Units
+    var escrow, value float64
+    for i := 0; i < len(book) && units > 0; i++ {
+        if book[i].AccountID == trade.AccountID {
+            if prevention != STP_CANCEL_OLDEST {break}
+            continue
+        }
+
+        fill := book[i].Units
+        if units < fill {fill = units}
+
+        notional := roundCents(book[i].Price * float64(fill))
+        escrow += notional + schedule.maxTradeFee(notional, account.Role)
+        value += notional
+        units -= fill
+    }
+
+    limits := config.Limits
+    if limits.MaxTradeValue > 0 && value > limits.MaxTradeValue {return account.Role, newError(ERR_INVALID_ARGUMENT, "Trade exceeds the maximum value")}
+    if account.Cash < escrow {return account.Role, newError(ERR_INSUFFICIENT_FUNDS, "Not enough cash to make this trade")}
+    account.Cash -= escrow
+    trade.Escrow = escrow
+
+    return account.Role, account.save(stub)
+}
+
+//==============================================================================================================================
+//     triggerStops - Match the property's dormant stops that the last price has reached, oldest first
+//==============================================================================================================================
+func triggerStops(stub *shim.ChaincodeStub, propertyID string) ([]Execution, error) {
+    var executions []Execution
+
+    resting, err := getPropertyTrades(stub, propertyID)
+    if checkErrors(err){return nil, err}
+
+    var stops []Trade
+    for i := 0; i < len(resting); i++ {
+        if resting[i].Status == TRADE_STATE_DORMANT {stops = append(stops, resting[i])}
+    }
+    sort.Sort(createdOrder(stops))
+
+    now, err := getTxTime(stub)
+    if checkErrors(err){return nil, err}
+
+    for i := 0; i < len(stops); i++ {
+        //reload as an earlier stop may have triggered this one while it was matching
+        var stop Trade
+        err = trades.get(stub, stops[i].ID, &stop)
+        if isNotFound(err) {continue}
+        if checkErrors(err){return nil, err}
+        if stop.Status != TRADE_STATE_DORMANT {continue}
+
+        if stop.expired(now) {
+            err = stop.cancel(stub, TRADE_STATE_EXPIRED)
+            if checkErrors(err){return nil, err}
+            continue
+        }
+
+        property, err := getProperty(stub, propertyID)
+        if checkErrors(err){return nil, err}
+        if !stop.stopTriggered(property.LastPrice) {continue}
+
+        filled, err := matchTrade(stub, &stop)
+        if checkErrors(err){return nil, err}
+        executions = append(executions, filled...)
+    }
+
+    return executions, nil
+}
+
+//==============================================================================================================================
+//     accept - Buy units, or all of them when units is 0, from the seller at the offer price. An offer with a buyer can
+//              only be accepted by them, and not once it has expired. Once every unit is taken the offer is removed and
+//              any underwriting commitment on it is released
+//==============================================================================================================================
+func (object *Offer) accept(stub *shim.ChaincodeStub, accountID string, units int) (Execution, error) {
+    var execution Execution
+    if units == 0 {units = object.Units}
+    if units < 0 || units > object.Units {return execution, newError(ERR_INVALID_ARGUMENT, "Offer " + object.ID + " has " + strconv.Itoa(object.Units) + " units available")}
+
+    property, err := getProperty(stub, object.PropertyID)
+    if checkErrors(err){return execution, err}
+    if property.closed() {return execution, newError(ERR_STATE_VIOLATION, "Property " + property.ID + " is no longer trading")}
+    err = checkOpen(stub, property)
+    if checkErrors(err){return execution, err}
+
+    //rights offers can only be taken up by their holder before they lapse
+    if object.Buyer != "" && object.Buyer != accountID {return execution, newError(ERR_UNAUTHORISED, "Offer " + object.ID + " is reserved for " + object.Buyer)}
+    now, err := getTxTime(stub)
+    if checkErrors(err){return execution, err}
+    if object.Expiry > 0 && object.Expiry <= now {return execution, newError(ERR_STATE_VIOLATION, "Offer " + object.ID + " has lapsed")}
+
+    buyer, err := getAccount(stub, accountID)
+    if checkErrors(err){return execution, err}
+    err = checkNotProperty(stub, buyer)
+    if checkErrors(err){return execution, err}
+    err = checkHoldingLimit(stub, buyer, object.PropertyID, units)
+    if checkErrors(err){return execution, err}
+
+    execution, err = object.fill(stub, accountID, units, object.Price, 0)
+    if checkErrors(err){return execution, err}
+
+    if object.Units == 0 {
+        err = releaseUnderwriting(stub, object.ID)
+        if checkErrors(err){return execution, err}
+    }
+
+    _, err = triggerStops(stub, object.PropertyID)
+    return execution, err
+}
+
+//==============================================================================================================================
+//     fill - Sell units of the offer to the account at price. The offer was resting so the seller is the maker. reserved
+//            is the buyer's cash already escrowed for the fill. The offer is removed once it has no units left
+//==============================================================================================================================
+func (object *Offer) fill(stub *shim.ChaincodeStub, accountID string, units int, price float64, reserved float64) (Execution, error) {
+    var execution Execution
+    var err error
+
+    execution.ID = getMd5Hash(getTxID(stub) + object.ID + accountID)
+    execution.PropertyID = object.PropertyID
+    execution.OfferID = object.ID
+    execution.BuyerID = accountID
+    execution.SellerID = object.Seller
+    execution.Price = price
+    execution.Units = units
+    execution.Timestamp, err = getTxTime(stub)
+    if checkErrors(err){return execution, err}
+
+    err = settle(stub, config.Fees, &execution, reserved, object.Escrowed, false, true)
+    if checkErrors(err){return execution, err}
+
+    object.Units -= units
+    if object.Units == 0 {return execution, object.delete(stub)}
+    return execution, object.save(stub)
+}
+
+//==============================================================================================================================
+//     withdraw - Take the offer off the market. Units escrowed on it go back to the seller
+//==============================================================================================================================
+func (object *Offer) withdraw(stub *shim.ChaincodeStub) error {
+    if object.Escrowed && object.Units > 0 {
+        seller, err := getAccount(stub, object.Seller)
+        if checkErrors(err){return err}
+        err = seller.changeHolding(object.PropertyID, object.Units)
+        if checkErrors(err){return err}
+        err = seller.save(stub)
+        if checkErrors(err){return err}
+    }
+    return object.delete(stub)
+}
+
+//==============================================================================================================================
+//     settle - Move cash, units and fees between the buyer and seller of an execution. reserved is the cash the buyer
+//              already has in escrow for this fill, and sellerEscrowed says whether the seller's units have already
+//              been taken out of their holding. Each side pays the maker or taker fee as it provided or took
+//              liquidity. Fees are itemised on the execution and on each side's ledger
+//==============================================================================================================================
+func settle(stub *shim.ChaincodeStub, schedule FeeSchedule, execution *Execution, reserved float64, sellerEscrowed bool, buyerMaker bool, sellerMaker bool) error {
+    notional := roundCents(execution.Price * float64(execution.Units))
+
+    buyer, err := getAccount(stub, execution.BuyerID)
+    if checkErrors(err){return err}
+    if buyer.Status != ACCOUNT_STATE_ACTIVE {return newError(ERR_STATE_VIOLATION, "Account " + buyer.ID + " is not active")}
+
+    execution.BuyerFee = schedule.tradeFee(notional, buyer.Role, buyerMaker)
+    buyer.Cash += reserved - notional - execution.BuyerFee
+    if buyer.Cash < 0 {return newError(ERR_INSUFFICIENT_FUNDS, "Not enough cash to settle this trade")}
+
+    err = buyer.changeHolding(execution.PropertyID, execution.Units)
+    if checkErrors(err){return err}
+    err = buyer.save(stub)
+    if checkErrors(err){return err}
+    err = postLedgerEntry(stub, buyer, LEDGER_BUY, -notional, execution.BuyerFee, execution.ID)
+    if checkErrors(err){return err}
+
+    //load the seller after saving the buyer in case they are the same account
+    seller, err := getAccount(stub, execution.SellerID)
+    if checkErrors(err){return err}
+
+    execution.SellerFee = schedule.tradeFee(notional, seller.Role, sellerMaker)
+    if !sellerEscrowed {
+        err = seller.changeHolding(execution.PropertyID, -execution.Units)
+        if checkErrors(err){return err}
+    }
+    seller.Cash += notional - execution.SellerFee
+
+    err = seller.save(stub)
+    if checkErrors(err){return err}
+    err = postLedgerEntry(stub, seller, LEDGER_SELL, notional, execution.SellerFee, execution.ID)
+    if checkErrors(err){return err}
+
+    //keep the property's view of its holders in step
+    propertyAccount, err := getAccount(stub, execution.PropertyID)
+    if checkErrors(err){return err}
+    err = propertyAccount.changeHolding(execution.SellerID, -execution.Units)
+    if checkErrors(err){return err}
+    err = propertyAccount.changeHolding(execution.BuyerID, execution.Units)
+    if checkErrors(err){return err}
+    err = propertyAccount.save(stub)
+    if checkErrors(err){return err}
+
+    //the last price triggers stops
+    property, err := getProperty(stub, execution.PropertyID)
+    if checkErrors(err){return err}
+    property.LastPrice = execution.Price
+    err = property.save(stub)
+    if checkErrors(err){return err}
+
+    err = collectFee(stub, schedule, execution.BuyerFee, execution.BuyerID, execution.ID)
+    if checkErrors(err){return err}
+    err = collectFee(stub, schedule, execution.SellerFee, execution.SellerID, execution.ID)
+    if checkErrors(err){return err}
+
+    return execution.save(stub)
+}
@@ -1,10 +1,10 @@
 package main
 
 import (
-    "errors"
     "fmt"
     "sort"
     "strconv"
+    "time"
     "crypto/md5"
     "encoding/hex"
     // "strings"
@@ -17,6 +17,7 @@ import (
     "net/http"
     "net/url"
     "io/ioutil"
+    "math"
     // "regexp"
 )
 
@@ -24,10 +25,13 @@ const   ROLE_MARKET_MAKER   =  0
 const   ROLE_MANAGER        =  1
 const   ROLE_PRIVATE_ENTITY =  2
 const   ROLE_EXCHANGE       =  3
+const   ROLE_ADMIN          =  4
+const   ROLE_PROPERTY       =  5    //a property's own account, holding its cap table and operating cash. It never trades
 
 const   PROPERTY_STATE_PROPOSED      =  0
 const   PROPERTY_STATE_MANAGED       =  1
 const   PROPERTY_STATE_RECLAIMED     =  2
+const   PROPERTY_STATE_SOLD          =  3
 
 const   ACCOUNT_STATE_ACTIVE       =  0
 const   ACCOUNT_STATE_INACTIVE     =  1
@@ -40,12 +44,61 @@ const   LOG_ERROR           =  4
 const   TRADE_BUY           =  "B"
 const   TRADE_SELL          =  "S"
 
+const   ORDER_LIMIT         =  "LIMIT"
+const   ORDER_MARKET        =  "MARKET"
+const   ORDER_STOP          =  "STOP"
+const   ORDER_STOP_LIMIT    =  "STOP_LIMIT"
+
+const   TIF_GTC             =  "GTC"
+const   TIF_IOC             =  "IOC"
+const   TIF_FOK             =  "FOK"
+const   TIF_GTD             =  "GTD"
+
+const   STP_CANCEL_NEWEST   =  "CANCEL_NEWEST"
+const   STP_CANCEL_OLDEST   =  "CANCEL_OLDEST"
+const   STP_CANCEL_BOTH     =  "CANCEL_BOTH"
+
+const   TRADE_STATE_OPEN        =  0
+const   TRADE_STATE_DORMANT     =  1
+const   TRADE_STATE_FILLED      =  2
+const   TRADE_STATE_CANCELLED   =  3
+const   TRADE_STATE_EXPIRED     =  4
+
+const   CASH_REQUEST_PENDING    =  0
+const   CASH_REQUEST_CONFIRMED  =  1
+const   CASH_REQUEST_REJECTED   =  2
+
 const   PROPERTY_PREFIX     = "property:"
 const   ACCOUNT_PREFIX      = "account:"
 const   TRDING_PRPTY_PREFIX = "trdprpty:"
 const   OFFER_PREFIX        = "offer:"
-const   ACCT_TRADES_PREFIX  = "accttrades:"
+const   TRADE_PREFIX        = "trade:"
+const   ACCT_TRADES_PREFIX  = "accttrades:"     //trade map blobs, only read by migrateTradeMaps
 const   PRPTY_TRADES_PREFIX = "prptytrades:"
+const   EXECUTION_PREFIX    = "execution:"
+const   LEDGER_PREFIX       = "ledger:"
+const   CASH_REQUEST_PREFIX = "cashrequest:"
+const   REQUEST_PREFIX      = "request:"
+const   SALE_PREFIX         = "sale:"
+const   OPERATING_PREFIX    = "operating:"
+const   PROPOSAL_PREFIX     = "proposal:"
+const   SNAPSHOT_PREFIX     = "snapshot:"
+const   UNDERWRITING_PREFIX = "underwriting:"
+const   CONFIG_KEY          = "config"
+
+const   LEDGER_DEPOSIT      = "DEPOSIT"
+const   LEDGER_WITHDRAWAL   = "WITHDRAWAL"
+const   LEDGER_BUY          = "BUY"
+const   LEDGER_SELL         = "SELL"
+const   LEDGER_FEE          = "FEE"
+const   LEDGER_ISSUANCE_FEE = "ISSUANCE_FEE"
+const   LEDGER_RECLAIM      = "RECLAIM"
+const   LEDGER_SALE         = "SALE"
+const   LEDGER_CASH_IN_LIEU = "CASH_IN_LIEU"
+const   LEDGER_RENT         = "RENT"
+const   LEDGER_EXPENSE      = "EXPENSE"
+const   LEDGER_DISTRIBUTION = "DISTRIBUTION"
+const   LEDGER_MANAGEMENT_FEE = "MANAGEMENT_FEE"
 
 
 //==============================================================================================================================
@@ -56,19 +109,6 @@ const   PRPTY_TRADES_PREFIX = "prptytrades:"
 type SimpleChaincode struct {
 }
 
-//==============================================================================================================================
-//    Log
-//==============================================================================================================================
-type Log struct {
-}
-
-//==============================================================================================================================
-//    Configuration
-//==============================================================================================================================
-type Configuration struct {
-    logLevel        int         `json:"logLevel"`
-}
-
 //==============================================================================================================================
 //    Property
 //==============================================================================================================================
@@ -84,7 +124,16 @@ type Property struct {
     ManagedBy       string      `json:"managedBy"`
     Issuer          string      `json:"issuer"`
     Units           int         `json:"units"`
+    Valuation       float64     `json:"valuation"`
+    LastPrice       float64     `json:"lastPrice"`
+    TickSize        float64     `json:"tickSize,omitempty"`
+    MinLot          int         `json:"minLot,omitempty"`
+    Halted          bool        `json:"halted"`
+    HaltedUntil     int64       `json:"haltedUntil,omitempty"`
+    Management      *ManagementAgreement    `json:"management,omitempty"`
+    Nomination      *ManagerNomination      `json:"nomination,omitempty"`
     Status          int         `json:"status"`
+    Version         int         `json:"version"`
     
 /*
   //comparison
@@ -109,8 +158,11 @@ type Property struct {
 type Account struct {
     ID              string      `json:"accountID"`
     Cash            float64     `json:"cash"`
+    Role            int         `json:"role"`
     Status          int         `json:"status"`
     Holdings        []Holding   `json:"holdings"`
+    Limits          *AccountLimits `json:"limits,omitempty"`
+    Version         int         `json:"version"`
 }
 
 //==============================================================================================================================
@@ -122,14 +174,16 @@ type Holding struct {
 }
 
 //==============================================================================================================================
-//    TradeMap
+//    TradeMap - How trades were stored before each had its own key, kept so old blobs can be migrated
 //==============================================================================================================================
 type TradeMap struct {
     Trades      map[string]Trade  `json:"trades"`
 }
 
 //==============================================================================================================================
-//    Trade
+//    Trade - Stop and stop limit orders stay dormant until the property's last price reaches StopPrice, then trade as
+//            market and limit orders. Market orders never rest so they are IOC or FOK. Expiry is only used with GTD.
+//            SelfTradePrevention overrides the configured mode when this trade would match one of the account's own
 //==============================================================================================================================
 type Trade struct {
     ID              string      `json:"tradeID"`
@@ -139,6 +193,41 @@ type Trade struct {
     Price           float64     `json:"price"`
     Units           int         `json:"units"`
     Escrow          float64     `json:"escrow"`
+    Created         int64       `json:"created"`
+    OrderType       string      `json:"orderType"`
+    TimeInForce     string      `json:"timeInForce"`
+    StopPrice       float64     `json:"stopPrice,omitempty"`
+    Expiry          int64       `json:"expiry,omitempty"`
+    SelfTradePrevention string  `json:"selfTradePrevention,omitempty"`
+    Status          int         `json:"status"`
+    Version         int         `json:"version"`
+}
+
+//==============================================================================================================================
+//    TradeResult - The trade as it rests after matching, along with any executions it generated
+//==============================================================================================================================
+type TradeResult struct {
+    Trade           Trade       `json:"trade"`
+    Executions      []Execution `json:"executions"`
+}
+
+//==============================================================================================================================
+//    Execution - A fill between a buyer and a seller, either from matching trades or from accepting an offer
+//==============================================================================================================================
+type Execution struct {
+    ID              string      `json:"executionID"`
+    PropertyID      string      `json:"propertyID"`
+    BuyTradeID      string      `json:"buyTradeID,omitempty"`
+    SellTradeID     string      `json:"sellTradeID,omitempty"`
+    OfferID         string      `json:"offerID,omitempty"`
+    BuyerID         string      `json:"buyerID"`
+    SellerID        string      `json:"sellerID"`
+    Price           float64     `json:"price"`
+    Units           int         `json:"units"`
+    BuyerFee        float64     `json:"buyerFee"`
+    SellerFee       float64     `json:"sellerFee"`
+    Timestamp       int64       `json:"timestamp"`
+    Version         int         `json:"version"`
 }
 
 //==============================================================================================================================
@@ -159,14 +248,20 @@ type TradingProperties struct {
 }
 
 //==============================================================================================================================
-//    Offer
+//    Offer - An offer can only be underwritten at MinFloorPrice or above, and not at all when the seller hasn't set one
 //==============================================================================================================================
 type Offer struct {
     ID              string      `json:"offerID"`
+    Seller          string      `json:"seller"`
     PropertyID      string      `json:"propertyID"`
     Direction       string      `json:"direction"`
     Price           float64     `json:"price"`
     Units           int         `json:"units"`
+    Buyer           string      `json:"buyer,omitempty"`
+    Expiry          int64       `json:"expiry,omitempty"`
+    Escrowed        bool        `json:"escrowed,omitempty"`
+    MinFloorPrice   float64     `json:"minFloorPrice,omitempty"`
+    Version         int         `json:"version"`
 }
 
 //==============================================================================================================================
@@ -183,7 +278,7 @@ type ECertResponse struct {
 //=================================================================================================================================
 
 var log    Log
-var config Configuration
+var config Configuration    //loaded from the ledger at the start of every call
 
 func main() {
     err := shim.Start(new(SimpleChaincode))
@@ -193,141 +288,146 @@ func main() {
 //==============================================================================================================================
 //    Init Function - Called when the user deploys the chaincode                                                                    
 //==============================================================================================================================
-func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) (result []byte, err error) {
     //authenticate the user
     //caller_ecert, caller_role, err := t.get_user_data(stub, args[0])
     //if checkErrors(err){return nil, err}
-    
-    var err error
+
+    log.begin(stub, function)
+    defer func() {
+        log.end(err)
+        err = errorEnvelope(err)
+    }()
+
+    //load the configuration after the deferred envelope so a failure here is reported like any other
+    config, err = getConfiguration(stub)
+    if checkErrors(err){return nil, err}
+
    /* 
     //make sure we have been configured up front
-    if config.logLevel == 0 && function != "configure" {
-        return nil, errors.New("Application hasn't been configured")
+    if config.LogLevel == 0 && function != "configure" {
+        return nil, newError(ERR_STATE_VIOLATION, "Application hasn't been configured")
     }
     */
 
     //set the log level
     switch function {
         case "configure":
-            configure(args)
+            err = configure(stub, args)
         case "test":
-            log.debug("*************************************")
-            log.debug(" Running Tests")
-            log.debug("*************************************")
+            log.info("*************************************")
+            log.info(" Running Tests")
+            log.info("*************************************")
             var output []string
+            output = append(output, t.testFreshLedgerQueries(stub)...)
             output = append(output, t.testAccountCreateSuccess(stub, "testaccount")...)
+            output = append(output, t.testRepositoryVersioning(stub, "testaccount")...)
+            output = append(output, t.testPagination(stub)...)
+            output = append(output, t.testCashRequests(stub)...)
+            output = append(output, t.testIdempotentReplay(stub)...)
+            output = append(output, t.testReclaimPayouts(stub)...)
+            output = append(output, t.testSaleProceeds(stub)...)
+            output = append(output, t.testSplitCashInLieu(stub)...)
+            output = append(output, t.testRightsEscrow(stub)...)
+            output = append(output, t.testDistribution(stub)...)
+            output = append(output, t.testSnapshotDistribution(stub)...)
+            output = append(output, t.testUnderwritingExercise(stub)...)
+            output = append(output, t.testTradeIDs(stub)...)
+            output = append(output, t.testTradeValueLimit(stub)...)
+            output = append(output, t.testSelfTradePrevention(stub)...)
+            output = append(output, t.testAccountLimits(stub)...)
+            output = append(output, t.testTradingSessions(stub)...)
+            output = append(output, t.testIssuedTradingState(stub)...)
+            output = append(output, t.testPriceRules(stub)...)
+            output = append(output, t.testManagerReplacement(stub)...)
+            output = append(output, t.testProposalVoting(stub)...)
+            output = append(output, t.testForcedUnderwriting(stub)...)
+            output = append(output, t.testTradeMapMigration(stub)...)
+            output = append(output, t.testStopTrigger()...)
+            output = append(output, t.testEquilibriumPrice()...)
+            output = append(output, t.testProposalTally()...)
+            output = append(output, t.testFeeScheduleDiscount()...)
 
             sort.Strings(output)
-            for i := 0; i<len(output); i++ {log.debug(output[i])}
+            for i := 0; i<len(output); i++ {log.info(output[i])}
 
         case "demo":
-            config.logLevel = LOG_DEBUG
+            config.LogLevel = LOG_DEBUG
+            config.save(stub)
+
+            //create the exchange's fee collection account, which only an admin could otherwise set up
+            exchange := Account{ID: "exchange", Role: ROLE_EXCHANGE, Status: ACCOUNT_STATE_ACTIVE}
+            exchange.create(stub)
+
+            //fund accounts the way a bank transfer would, with a deposit request the exchange confirms
+            deposit := func(accountID string, amount string) {
+                bytes, _ := t.dispatch(stub, FUNCTION_INVOKE, "requestDeposit", []string{accountID, amount, "DEMO-" + accountID})
+                var request CashRequest
+                json.Unmarshal(bytes, &request)
+                t.dispatch(stub, FUNCTION_INVOKE, "confirmCashRequest", []string{exchange.ID, request.ID})
+            }
 
             //create the cardy account
-            t.createAccount(stub, []string{"cardy"})
-            t.depositCash(stub, []string{"cardy", "1000000"})
-            t.issueProperty(stub, []string{`{addressLine: "30 Oakwood St", suburb: "Sutherland", state: "NSW", postcode: "2232", issuer: "cardy", units: 10000, valuation: 10000000}`})
+            t.dispatch(stub, FUNCTION_INVOKE, "createAccount", []string{"cardy"})
+            deposit("cardy", "1000000")
+            t.dispatch(stub, FUNCTION_INVOKE, "issueProperty", []string{`{"addressLine": "30 Oakwood St", "suburb": "Sutherland", "state": "NSW", "postcode": "2232", "issuer": "cardy", "units": 10000, "valuation": 10000000}`})
 
-            t.createAccount(stub, []string{"cripps"})
-            t.depositCash(stub, []string{"cripps", "200000"})
-            t.issueProperty(stub, []string{`{addressLine: "25a National Ave", suburb: "Loftus", state: "NSW", postcode: "2232", issuer: "cripps", units: 1400, valuation: 14000000}}`})
-            t.issueProperty(stub, []string{`{addressLine: "43a Belmont St", suburb: "Sutherland", state: "NSW", postcode: "2232", issuer: "cripps", units: 800, valuation: 12000000}}`})
+            t.dispatch(stub, FUNCTION_INVOKE, "createAccount", []string{"cripps"})
+            deposit("cripps", "200000")
+            t.dispatch(stub, FUNCTION_INVOKE, "issueProperty", []string{`{"addressLine": "25a National Ave", "suburb": "Loftus", "state": "NSW", "postcode": "2232", "issuer": "cripps", "units": 1400, "valuation": 14000000}`})
+            t.dispatch(stub, FUNCTION_INVOKE, "issueProperty", []string{`{"addressLine": "43a Belmont St", "suburb": "Sutherland", "state": "NSW", "postcode": "2232", "issuer": "cripps", "units": 800, "valuation": 12000000}`})
 
             
-            t.createAccount(stub, []string{"m123456"})
-            t.depositCash(stub, []string{"m123456", "200000"})
+            t.dispatch(stub, FUNCTION_INVOKE, "createAccount", []string{"m123456"})
+            deposit("m123456", "200000")
 
         default:
-            err = errors.New("You must choose an initialisation mode")
+            err = newError(ERR_INVALID_ARGUMENT, "You must choose an initialisation mode")
     }
-    config.logLevel = LOG_DEBUG
 
     return nil, err
  }
 
 //=================================================================================================================================    
-//    Query - Called on chaincode query. Takes a function name passed and calls that function. Passes the
-//          initial arguments passed are passed on to the called function.
+//    Query - Called on chaincode query. Looks the function up in the registry, which parses the arguments
+//          and passes them on to the called function.
 //=================================================================================================================================    
-func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) (result []byte, err error) {
     //authenticate the user
     //caller_ecert, caller_role, err := t.get_user_data(stub, args[0])
     //if checkErrors(err){return nil, err}
 
-    // switch function {
-    //     case "login":
-    //         return t.login(stub, args)
-    //     case "getAccount":
-    //         return t.getAccount(stub, args)
-    //     case "getProperties":
-    //         return t.getProperties(stub, args)
-    //     case "getOpenTradesByAccount":
-    //         return t.getOpenTradesByAccount(stub, args)
-    //     case "getAvailableTrades":
-    //         return t.getAvailableTrades(stub, args)
-    //     default:
-    //         return nil, errors.New("Invalid function (" + function + ") called")
-    // }
-    if function == "login" {
-        return t.login(stub, args)
-    } else if function == "getAccount" {
-        return t.getAccount(stub, args)
-    } else if function == "getProperties" {
-        return t.getProperties(stub, args)        
-    } else if function == "getOpenTradesByAccount" {
-        return t.getOpenTradesByAccount(stub, args)
-    } else if function == "getAvailableTrades" {
-        return t.getAvailableTrades(stub, args)
-    } else {
-        return nil, errors.New("Invalid function (" + function + ") called")
-    }
+    log.begin(stub, function)
+    defer func() {
+        log.end(err)
+        err = errorEnvelope(err)
+    }()
+
+    config, err = getConfiguration(stub)
+    if checkErrors(err){return nil, err}
+
+    return t.dispatch(stub, FUNCTION_QUERY, function, args)
 }
 
 //==============================================================================================================================
-//    Invoke - Called on chaincode invoke. Takes a function name passed and calls that function. Converts some
-//             initial arguments passed to other things for use in the called function e.g. name -> ecert
+//    Invoke - Called on chaincode invoke. Looks the function up in the registry, which parses the arguments
+//             and checks the caller's role before calling the function
 //==============================================================================================================================
-func (t *SimpleChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) (result []byte, err error) {
     //authenticate the user
     //caller_ecert, caller_role, err := t.get_user_data(stub, args[0])
     //if checkErrors(err){return nil, err}
 
-    // switch function {
-    //     case "depositCash":
-    //         return t.depositCash(stub, args)
-    //     case "withdrawCash":
-    //         return t.withdrawCash(stub, args)
-    //     case "createTrade":
-    //         return t.createTrade(stub, args)
-    //     case "createAccount":
-    //         return t.createAccount(stub, args)
-    //     case "issueProperty":
-    //         return t.issueProperty(stub, args)
-    //     case "generateOffer":
-    //         return t.generateOffer(stub, args)
-    //     case "acceptOffer":
-    //         return t.acceptOffer(stub, args)
-    //     default:
-    //         return nil, errors.New("Invalid function (" + function + ") called")
-    // }
-    if function == "depositCash" {
-        return t.depositCash(stub, args)        
-    } else if function == "withdrawCash" {
-        return t.withdrawCash(stub, args)
-    } else if function == "createTrade" {
-        return t.createTrade(stub, args)    
-    } else if function == "createAccount" {
-        return t.createAccount(stub, args)        
-    } else if function == "issueProperty" {
-        return t.issueProperty(stub, args)
-    } else if function == "generateOffer" {
-        return t.generateOffer(stub, args) 
-    } else if function == "acceptOffer" {
-        return t.acceptOffer(stub, args)
-    } else {
-        return nil, errors.New("Invalid function (" + function + ") called")     
-    }
+    log.begin(stub, function)
+    defer func() {
+        log.end(err)
+        err = errorEnvelope(err)
+    }()
+
+    config, err = getConfiguration(stub)
+    if checkErrors(err){return nil, err}
 
+    return t.dispatch(stub, FUNCTION_INVOKE, function, args)
 }
 
 //==============================================================================================================================
@@ -335,8 +435,7 @@ func (t *SimpleChaincode) Invoke(stub *shim.ChaincodeStub, function string, args
 //==============================================================================================================================
 //     login
 //==============================================================================================================================
-func (t *SimpleChaincode) login(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-//login(accountID string)
+func (t *SimpleChaincode) login(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
     //currently just return the account
     return t.getAccount(stub, args)
 }
@@ -344,10 +443,8 @@ func (t *SimpleChaincode) login(stub *shim.ChaincodeStub, args []string) ([]byte
 //==============================================================================================================================
 //     getAccount
 //==============================================================================================================================
-func (t *SimpleChaincode ) getAccount(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-    //getAccount(accountID string)
-    if len(args) != 1 {return nil, errors.New("Incorrect number of arguments passed")}
-    accountID := args[0]
+func (t *SimpleChaincode ) getAccount(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    accountID := args.String("accountID")
 
     account, err := getAccount(stub, accountID)
     if checkErrors(err) {return nil, err}
@@ -359,11 +456,10 @@ func (t *SimpleChaincode ) getAccount(stub *shim.ChaincodeStub, args []string) (
 //==============================================================================================================================
 //     getProperties
 //==============================================================================================================================
-func (t *SimpleChaincode) getProperties(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-    //getProperties(propertyIDs []string)
-    var propertyIDs = args
+func (t *SimpleChaincode) getProperties(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    var propertyIDs = args.Strings("propertyIDs")
 
-    var properties []Property
+    properties := []Property{}
 
     for i:=0;i<len(propertyIDs);i++ {
         property, err := getProperty(stub, propertyIDs[i])
@@ -377,13 +473,11 @@ func (t *SimpleChaincode) getProperties(stub *shim.ChaincodeStub, args []string)
 //==============================================================================================================================
 //     getOpenTradesByAccount
 //==============================================================================================================================
-func (t *SimpleChaincode ) getOpenTradesByAccount(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-    //getOpenTradesByAccount(accountID string)
-    if len(args) != 1 {return nil, errors.New("Incorrect number of arguments passed")}
-    accountID := args[0]
+func (t *SimpleChaincode ) getOpenTradesByAccount(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    accountID := args.String("accountID")
 
-    var account Account
-    account.ID = accountID
+    account, err := getAccount(stub, accountID)
+    if checkErrors(err) {return nil, err}
 
     trades, err := account.getTrades(stub)
     if checkErrors(err) {return nil, err}
@@ -394,14 +488,11 @@ func (t *SimpleChaincode ) getOpenTradesByAccount(stub *shim.ChaincodeStub, args
 //==============================================================================================================================
 //     getAvailableTrades
 //==============================================================================================================================
-func (t *SimpleChaincode ) getAvailableTrades(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-    //getAvailableTrades()
-    if len(args) != 0 {return nil, errors.New("Incorrect number of arguments passed")}
-    
+func (t *SimpleChaincode ) getAvailableTrades(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
     propertyIDs, err := getTradingProperties(stub)
     if checkErrors(err){return nil, err}
 
-    var returnTrades []ReturnTrade
+    returnTrades := []ReturnTrade{}
 
     for i:=0;i<len(propertyIDs);i++ {
         //for this property create a return trade
@@ -413,13 +504,14 @@ func (t *SimpleChaincode ) getAvailableTrades(stub *shim.ChaincodeStub, args []s
         if checkErrors(err){return nil, err}
 
         for j:=0;j<len(trades);j++ {
+            if trades[j].Status != TRADE_STATE_OPEN {continue}
+            returnTrade.Direction = trades[j].Direction
             returnTrade.Units += trades[j].Units
             tradeValue := float64(trades[j].Units) * trades[j].Price
             value += tradeValue
         }
         if returnTrade.Units == 0 {continue}
-        
-        returnTrade.Direction = trades[0].Direction
+
         returnTrade.Price = value / float64(returnTrade.Units)
         returnTrades = append(returnTrades, returnTrade)
     }
@@ -430,92 +522,245 @@ func (t *SimpleChaincode ) getAvailableTrades(stub *shim.ChaincodeStub, args []s
 //==============================================================================================================================
 //     Invoke Logic Methods
 //==============================================================================================================================
-//     depositCash - Transfer cash into a blockchain account
+//     depositCash - Transfer cash into a blockchain account straight away. Only the exchange can do this, to correct
+//                   a balance. Bank transfers go through requestDeposit so the exchange confirms them
 //==============================================================================================================================
-func (t *SimpleChaincode ) depositCash(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-    //depositCash(accountID, value)
-    if len(args) != 2 {return nil, errors.New("Incorrect number of arguments passed")}
-
-    account, err := getAccount(stub, args[0])
+func (t *SimpleChaincode ) depositCash(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    account, err := getAccount(stub, args.String("accountID"))
     if checkErrors(err){return nil, err}
 
-    cashValue, err := strconv.ParseFloat(args[1], 64)
-    if checkErrors(err){return nil, errors.New("Could not parse "+args[1]+" to float")}
+    cashValue := args.Float("value")
+    if cashValue <= 0 {return nil, newError(ERR_INVALID_ARGUMENT, "Deposit value must be positive")}
 
     account.Cash += cashValue
-    account.save(stub)
-    return nil, err
+    err = account.save(stub)
+    if checkErrors(err){return nil, err}
+
+    log.info("Deposited cash", "accountID", account.ID, "amount", cashValue, "cash", account.Cash)
+
+    return nil, postLedgerEntry(stub, account, LEDGER_DEPOSIT, cashValue, 0, "")
 }
 
 //==============================================================================================================================
-//     withdrawCash - Transfer cash out of a blockchain account
+//     withdrawCash - Transfer cash out of a blockchain account straight away. Only the exchange can do this, to
+//                    correct a balance. Bank transfers go through requestWithdrawal so the exchange confirms them
 //==============================================================================================================================
-func (t *SimpleChaincode ) withdrawCash(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-    //withdrawCash(accountID, value)
-    if len(args) != 2 {return nil, errors.New("Incorrect number of arguments passed")}
+func (t *SimpleChaincode ) withdrawCash(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    account, err := getAccount(stub, args.String("accountID"))
+    if checkErrors(err){return nil, err}
 
-    account, err := getAccount(stub, args[0])
+    cashValue := args.Float("value")
+    if cashValue <= 0 {return nil, newError(ERR_INVALID_ARGUMENT, "Withdrawal value must be positive")}
+    if config.Limits.MaxWithdrawal > 0 && cashValue > config.Limits.MaxWithdrawal {return nil, newError(ERR_INVALID_ARGUMENT, "Withdrawal exceeds the maximum value")}
+    err = checkWithdrawalLimit(stub, account, cashValue)
     if checkErrors(err){return nil, err}
 
-    cashValue, err := strconv.ParseFloat(args[1], 64)
-    if checkErrors(err){return nil, errors.New("Could not parse "+args[1]+" to float")}
+    schedule := config.Fees
+    fee := schedule.flatFee(schedule.WithdrawalFee, account.Role)
 
-    if account.Cash < cashValue {
-        return nil, errors.New("Not enough cash to withdraw")
+    if account.Cash < cashValue + fee {
+        return nil, newError(ERR_INSUFFICIENT_FUNDS, "Not enough cash to withdraw")
     }
-    account.Cash -= cashValue
-    account.save(stub)
-    return nil, err
+    account.Cash -= cashValue + fee
+    err = account.save(stub)
+    if checkErrors(err){return nil, err}
+
+    log.info("Withdrew cash", "accountID", account.ID, "amount", cashValue, "fee", fee, "cash", account.Cash)
+
+    err = postLedgerEntry(stub, account, LEDGER_WITHDRAWAL, -cashValue, fee, "")
+    if checkErrors(err){return nil, err}
+
+    return nil, collectFee(stub, schedule, fee, account.ID, LEDGER_WITHDRAWAL)
 }
 
 //==============================================================================================================================
 //     createTrade - Purchase units of a property
 //==============================================================================================================================
-func (t *SimpleChaincode ) createTrade(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-    //createTrade(trade string) {accountID: "m123456", direction: "S", propertyID: "qwer1234", price: "100.00", units: "10"}
-    if len(args) != 1 {return nil, errors.New("Incorrect number of arguments passed")}
+func (t *SimpleChaincode ) createTrade(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    //createTrade(trade string) {"accountID": "m123456", "direction": "S", "propertyID": "qwer1234", "price": 100.00, "units": 10}
+    trade := *args.Body("trade").(*Trade)
 
-    return nil, nil
+    log.debug("escrowing funds for trade", "propertyID", trade.PropertyID)
+    err := trade.create(stub)
+    if checkErrors(err){return nil, err}
+
+    var result TradeResult
+    if trade.Status != TRADE_STATE_CANCELLED {
+        log.debug("matching trade", "tradeID", trade.ID, "escrow", trade.Escrow)
+        result.Executions, err = matchTrade(stub, &trade)
+        if checkErrors(err){return nil, err}
+    }
+    result.Trade = trade
+
+    log.info("Created trade", "tradeID", trade.ID, "executions", len(result.Executions))
+    return result.marshal()
 }
 
 //==============================================================================================================================
-//     createAccount - Create an account for a user
+//     expireTrades - Take the property's GTD trades that have expired off the book. Matching does this as it goes, this
+//                    is for properties that aren't trading
 //==============================================================================================================================
-func (t *SimpleChaincode ) createAccount(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+func (t *SimpleChaincode ) expireTrades(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    propertyID := args.String("propertyID")
 
-    if len(args) != 1 {return nil, errors.New("Incorrect number of arguments passed")}
-    accountID := args[0]
+    now, err := getTxTime(stub)
+    if checkErrors(err){return nil, err}
+
+    resting, err := getPropertyTrades(stub, propertyID)
+    if checkErrors(err){return nil, err}
+
+    expired := []Trade{}
+    for i := 0; i < len(resting); i++ {
+        if !resting[i].expired(now) {continue}
+
+        err = resting[i].cancel(stub, TRADE_STATE_EXPIRED)
+        if checkErrors(err){return nil, err}
+        expired = append(expired, resting[i])
+    }
+
+    log.info("Expired trades", "propertyID", propertyID, "expired", len(expired))
+    return marshalTrades(expired)
+}
+
+//==============================================================================================================================
+//     createAccount - Create an account for a user. Every account starts as a private entity, only an admin can give
+//                     it another role
+//==============================================================================================================================
+func (t *SimpleChaincode ) createAccount(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    accountID := args.String("accountID")
 
-    if len(args) != 1 {return nil, errors.New("Incorrect number of arguments passed")}
     var account Account
     account.ID = accountID
+    account.Role = ROLE_PRIVATE_ENTITY
     account.Status = ACCOUNT_STATE_ACTIVE
-    if account.exists(stub) {return nil, errors.New("account already exists")}
+    if account.exists(stub) {return nil, newError(ERR_CONFLICT, "account already exists")}
     return nil, account.create(stub)
 }
 
 //==============================================================================================================================
-//     generateOffer - buy into a new property issue
+//     setAccountRole - Make an account a market maker, manager, private entity or exchange. The registry makes sure only
+//                      an admin can do this
 //==============================================================================================================================
-func (t *SimpleChaincode ) generateOffer(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-    //generateOffer(propertyID string, units int)
-    if len(args) != 2 {return nil, errors.New("Incorrect number of arguments passed")}
-    //propertyID := args[0]
-    //units, err := strconv.Atoi(args[1])
-    //if checkErrors(err) {return nil, err}
+func (t *SimpleChaincode ) setAccountRole(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    role := args.Int("role")
+    if role < ROLE_MARKET_MAKER || role > ROLE_EXCHANGE {return nil, newError(ERR_INVALID_ARGUMENT, "Invalid role " + strconv.Itoa(role))}
 
-    return nil, nil
+    account, err := getAccount(stub, args.String("accountID"))
+    if checkErrors(err){return nil, err}
+    if account.Role == ROLE_ADMIN {return nil, newError(ERR_INVALID_ARGUMENT, "Can't change the role of an admin")}
+    err = checkNotProperty(stub, account)
+    if checkErrors(err){return nil, err}
+
+    account.Role = role
+    err = account.save(stub)
+    if checkErrors(err){return nil, err}
+
+    log.info("Set account role", "accountID", account.ID, "role", role)
+    return account.marshal()
+}
+
+//==============================================================================================================================
+//     generateOffer - The issuer offers units of a new property issue. An offer period closes the offer after that many
+//                     seconds, and a minimum floor price lets market makers underwrite it at that price or above
+//==============================================================================================================================
+func (t *SimpleChaincode ) generateOffer(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    propertyID := args.String("propertyID")
+    units := args.Int("units")
+    offerPeriod := int64(args.Int("offerPeriod"))
+    if offerPeriod < 0 {return nil, newError(ERR_INVALID_ARGUMENT, "Offer period can't be negative")}
+
+    property, err := getProperty(stub, propertyID)
+    if checkErrors(err) {return nil, err}
+    if property.closed() || property.Units <= 0 {return nil, newError(ERR_STATE_VIOLATION, "Property " + property.ID + " is no longer trading")}
+    if args.String("issuerID") != property.Issuer {return nil, newError(ERR_UNAUTHORISED, "Only the issuer can offer units of property " + property.ID)}
+
+    //the issue is offered at the issuer's valuation
+    var offer Offer
+    offer.PropertyID = property.ID
+    offer.Seller = property.Issuer
+    offer.Direction = TRADE_BUY
+    offer.Units = units
+    offer.Price = property.Valuation / float64(property.Units)
+    offer.MinFloorPrice = args.Float("minFloorPrice")
+    if offer.MinFloorPrice < 0 || offer.MinFloorPrice > offer.Price {return nil, newError(ERR_INVALID_ARGUMENT, "Minimum floor price can't be negative or more than the offer price")}
+    if offerPeriod > 0 {
+        now, err := getTxTime(stub)
+        if checkErrors(err) {return nil, err}
+        offer.Expiry = now + offerPeriod
+    }
+
+    err = offer.create(stub)
+    if checkErrors(err) {return nil, err}
+
+    return offer.marshal()
+}
+
+//==============================================================================================================================
+//     acceptOffer - buy into a new property issue, taking all of the offer's units unless fewer are asked for
+//==============================================================================================================================
+func (t *SimpleChaincode ) acceptOffer(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    offerID := args.String("offerID")
+    accountID := args.String("accountID")
+
+    offer, err := getOffer(stub, offerID)
+    if checkErrors(err) {return nil, err}
+
+    execution, err := offer.accept(stub, accountID, args.Int("units"))
+    if checkErrors(err) {return nil, err}
+
+    log.info("Accepted offer", "offerID", offer.ID, "accountID", accountID)
+    return execution.marshal()
 }
 
 //==============================================================================================================================
-//     acceptOffer - buy into a new property issue
+//     transfer - Move units between accounts off market, no cash changes hands. Only the exchange can do this, for
+//                transfers it has evidence of outside the market such as a deceased estate or a court order, because
+//                otherwise anyone could move anyone's units
 //==============================================================================================================================
-func (t *SimpleChaincode ) acceptOffer(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-    //acceptOffer(offerID string, accountID string)
-    if len(args) != 2 {return nil, errors.New("Incorrect number of arguments passed")}
-    //offerID := args[0]
-    //accountID := args[1]
+func (t *SimpleChaincode ) transfer(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    propertyID := args.String("propertyID")
+    units := args.Int("units")
+    if units <= 0 {return nil, newError(ERR_INVALID_ARGUMENT, "Transfer must be for a positive number of units")}
+
+    property, err := getProperty(stub, propertyID)
+    if checkErrors(err){return nil, err}
+    if property.closed() {return nil, newError(ERR_STATE_VIOLATION, "Property " + property.ID + " is no longer trading")}
+
+    from, err := getAccount(stub, args.String("fromAccountID"))
+    if checkErrors(err){return nil, err}
+    to, err := getAccount(stub, args.String("toAccountID"))
+    if checkErrors(err){return nil, err}
+    if from.ID == to.ID {return nil, newError(ERR_INVALID_ARGUMENT, "Can't transfer to the same account")}
+    if from.Status != ACCOUNT_STATE_ACTIVE || to.Status != ACCOUNT_STATE_ACTIVE {return nil, newError(ERR_STATE_VIOLATION, "Both accounts must be active")}
+    err = checkNotProperty(stub, from)
+    if checkErrors(err){return nil, err}
+    err = checkNotProperty(stub, to)
+    if checkErrors(err){return nil, err}
+
+    err = checkHoldingLimit(stub, to, propertyID, units)
+    if checkErrors(err){return nil, err}
 
+    err = from.changeHolding(propertyID, -units)
+    if checkErrors(err){return nil, err}
+    err = from.save(stub)
+    if checkErrors(err){return nil, err}
+
+    err = to.changeHolding(propertyID, units)
+    if checkErrors(err){return nil, err}
+    err = to.save(stub)
+    if checkErrors(err){return nil, err}
+
+    //keep the property's view of its holders in step
+    propertyAccount, err := getAccount(stub, propertyID)
+    if checkErrors(err){return nil, err}
+    err = propertyAccount.changeHolding(from.ID, -units)
+    if checkErrors(err){return nil, err}
+    err = propertyAccount.changeHolding(to.ID, units)
+    if checkErrors(err){return nil, err}
+    err = propertyAccount.save(stub)
+    if checkErrors(err){return nil, err}
+
+    log.info("Transferred units", "propertyID", propertyID, "from", from.ID, "to", to.ID, "units", units)
     return nil, nil
 }
 
@@ -523,43 +768,95 @@ func (t *SimpleChaincode ) acceptOffer(stub *shim.ChaincodeStub, args []string)
 //     issueProperty - Issue a property for trading on the block chain. The property's units will automatically be assigned
 //                     to the account of the issuer
 //==============================================================================================================================
-func (t *SimpleChaincode ) issueProperty(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+func (t *SimpleChaincode ) issueProperty(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    property := *args.Body("property").(*Property)
 
-    log.debug("check issueProperty args")
-    if len(args) != 1 {return nil, errors.New("Incorrect number of arguments. Expecting property json")}
+    //the issuer only describes the property, its trading state starts afresh
+    property.Management, property.Nomination = nil, nil
+    property.LastPrice, property.Halted, property.HaltedUntil, property.Version = 0, false, 0, 0
+    property.Status = PROPERTY_STATE_PROPOSED
+    if property.ManagedBy != "" {
+        agreement, err := newManagementAgreement(stub, property.ManagedBy, 0, 0)
+        if checkErrors(err){return nil, err}
+        property.appoint(agreement)
+    }
 
-    log.debug("unmarshalling " + args[0])
-    property, err := unmarshalProperty([]byte(args[0]))
-    if checkErrors(err){return nil, err}
-    
     log.debug("creating the property in the blockchain")
-    err = property.create(stub)
+    err := property.create(stub)
     if checkErrors(err){return nil, err}
     
-    log.debug("get the account for the issuer " + property.Issuer)
+    log.debug("get the account for the issuer", "issuer", property.Issuer)
     issuerAccount, err := getAccount(stub, property.Issuer)
     if checkErrors(err){return nil, err}
 
+    log.debug("charge the issuance fee")
+    schedule := config.Fees
+    fee := schedule.flatFee(schedule.IssuanceFee, issuerAccount.Role)
+    if issuerAccount.Cash < fee {return nil, newError(ERR_INSUFFICIENT_FUNDS, "Not enough cash to pay the issuance fee")}
+    issuerAccount.Cash -= fee
+
     log.debug("Set the issuer to be the owner of all units")
-    issuerAccount.changeHolding(property.ID, property.Units)
+    err = issuerAccount.changeHolding(property.ID, property.Units)
     if checkErrors(err){return nil, err}
 
     log.debug("save the issuer's account")
     err = issuerAccount.save(stub)
     if checkErrors(err){return nil, err}
 
+    if fee > 0 {
+        err = postLedgerEntry(stub, issuerAccount, LEDGER_ISSUANCE_FEE, 0, fee, property.ID)
+        if checkErrors(err){return nil, err}
+        err = collectFee(stub, schedule, fee, issuerAccount.ID, property.ID)
+        if checkErrors(err){return nil, err}
+    }
+
     log.debug("now create an account for the property with an initial view of the holdings")
     var propertyAccount Account
     propertyAccount.ID = property.ID
+    propertyAccount.Role = ROLE_PROPERTY
     propertyAccount.Cash = 0
-    propertyAccount.changeHolding(property.Issuer, property.Units)
+    err = propertyAccount.changeHolding(property.Issuer, property.Units)
     if checkErrors(err){return nil, err}
 
     err = propertyAccount.create(stub)
     if checkErrors(err){return nil, err}    
     
-    log.info("Issued property " + property.ID)
+    log.info("Issued property", "propertyID", property.ID)
+
+    return nil, nil
+}
+
+//==============================================================================================================================
+//     migrateTradeMaps - Give every trade in the old per account and per property trade map blobs its own key, then delete
+//                        the blobs. Trades that already have a key are left alone so it is safe to run more than once
+//==============================================================================================================================
+func (t *SimpleChaincode ) migrateTradeMaps(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    var migrated, blobs int
+
+    prefixes := []string{ACCT_TRADES_PREFIX, PRPTY_TRADES_PREFIX}
+    for i := 0; i < len(prefixes); i++ {
+        keys, tradeMaps, err := getTradeMapBlobs(stub, prefixes[i])
+        if checkErrors(err){return nil, err}
+
+        for j := 0; j < len(keys); j++ {
+            for _, trade := range tradeMaps[j].Trades {
+                found, err := trades.exists(stub, trade.ID)
+                if checkErrors(err){return nil, err}
+                if found {continue}
+
+                trade.Version = 0
+                err = trade.save(stub)
+                if checkErrors(err){return nil, err}
+                migrated++
+            }
+
+            err = stub.DelState(keys[j])
+            if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Couldn't delete trade map " + keys[j], err)}
+            blobs++
+        }
+    }
 
+    log.info("Migrated trade maps", "trades", migrated, "tradeMaps", blobs)
     return nil, nil
 }
 
@@ -570,32 +867,39 @@ func (t *SimpleChaincode ) issueProperty(stub *shim.ChaincodeStub, args []string
 //==============================================================================================================================
 func getProperty(stub *shim.ChaincodeStub, id string) (Property, error) {
     var object Property
-    bytes, err := stub.GetState(PROPERTY_PREFIX + id)
-    if checkErrors(err){return object, errors.New("Couldn't retrieve property for " + id)}
-
-    object, err = unmarshalProperty(bytes)
-    if checkErrors(err){return object, err}
-
-    return object, nil
+    err := properties.get(stub, id, &object)
+    return object, err
 }
 
+//getTradingProperties - the IDs of every property that has been traded, sorted. Empty until the first trade is made
 func getTradingProperties(stub *shim.ChaincodeStub) ([]string, error) {
-    var tradingProperties TradingProperties
-    var propertyIDs []string
-
-    bytes, err := stub.GetState(TRDING_PRPTY_PREFIX)
-    if checkErrors(err){return nil, errors.New("Couldn't retrieve trading properties")}
+    propertyIDs := []string{}
 
-    tradingProperties, err = unmarshalTradingProperties(bytes)
+    tradingProperties, err := getTradingPropertiesIndex(stub)
     if checkErrors(err){return nil, err}
 
     for _, value := range tradingProperties.PropertyIDs {
         propertyIDs = append(propertyIDs, value)
     }
+    sort.Strings(propertyIDs)
 
     return propertyIDs, nil
 }
 
+func getTradingPropertiesIndex(stub *shim.ChaincodeStub) (TradingProperties, error) {
+    var tradingProperties TradingProperties
+    bytes, err := stub.GetState(TRDING_PRPTY_PREFIX)
+    if checkErrors(err){return tradingProperties, wrapError(ERR_INTERNAL, "Couldn't retrieve trading properties", err)}
+
+    if bytes != nil && len(bytes) > 0 {
+        tradingProperties, err = unmarshalTradingProperties(bytes)
+        if checkErrors(err){return tradingProperties, err}
+    }
+    if tradingProperties.PropertyIDs == nil {tradingProperties.PropertyIDs = map[string]string{}}
+
+    return tradingProperties, nil
+}
+
 func getPropertyTrades(stub *shim.ChaincodeStub, propertyID string) ([]Trade, error) {
     var object Property
     object.ID = propertyID
@@ -603,46 +907,22 @@ func getPropertyTrades(stub *shim.ChaincodeStub, propertyID string) ([]Trade, er
 }
 
 func (object *Property) getTrades(stub *shim.ChaincodeStub) ([]Trade, error) {
-    var trades []Trade
-    var tradeMap map[string]Trade
-    if object.ID == "" {return trades, errors.New("Need a property ID to search on")}
-
-    bytes, err := stub.GetState(PRPTY_TRADES_PREFIX + object.ID)
-    if checkErrors(err){return trades, errors.New("Couldn't retrieve trades for " + object.ID)}
-    if bytes != nil && len(bytes) > 0 {
-        tradeMapObj, err := unmarshalTradeMap(bytes)
-        if checkErrors(err){return trades, err}
-        tradeMap = tradeMapObj.Trades
-    } else {
-        tradeMap = map[string]Trade{}
-    }
-
-    for _, value := range tradeMap {
-        trades = append(trades, value)
-    }
-
-    return trades, nil
+    if object.ID == "" {return []Trade{}, newError(ERR_INVALID_ARGUMENT, "Need a property ID to search on")}
+    return getIndexedTrades(stub, "property", object.ID)
 }
 
 func (object *Property) create(stub *shim.ChaincodeStub) error {
     err := object.validate()
     if checkErrors(err){return err}
 
-    if object.ID != "" {return errors.New("Can't create property with ID already assigned")}
+    if object.ID != "" {return newError(ERR_INVALID_ARGUMENT, "Can't create property with ID already assigned")}
     object.ID = getMd5Hash(object.AddressLine + object.Suburb + object.State + object.PostCode)
-    if object.exists(stub) {return errors.New("A property with this ID already exists")}
 
-    return object.save(stub)
+    return properties.create(stub, object)
 }
 
 func (object *Property) save(stub *shim.ChaincodeStub) error {
-    bytes, err := object.marshal()
-    if checkErrors(err){return err}
-    
-    err = stub.PutState(PROPERTY_PREFIX + object.ID, bytes)
-    if checkErrors(err){return errors.New("Couldn't save property for " + object.ID + " " + object.AddressLine)}
-
-    return nil
+    return properties.save(stub, object)
 }
 
 func deleteProperty(stub *shim.ChaincodeStub, id string) error {
@@ -653,20 +933,35 @@ func deleteProperty(stub *shim.ChaincodeStub, id string) error {
 }
 
 func (object *Property) delete(stub *shim.ChaincodeStub) error {
-    object.Status = PROPERTY_STATE_RECLAIMED
-    err := object.save(stub)
-    if checkErrors(err){return errors.New("Couldn't delete property for " + object.ID)}
-
-    return nil
+    return properties.delete(stub, object)
 }
 
 func (object *Property) exists(stub *shim.ChaincodeStub) bool {
-    bytes, err := stub.GetState(PROPERTY_PREFIX + object.ID)
-    return bytes != nil || err != nil
+    found, err := properties.exists(stub, object.ID)
+    return found || err != nil
 }
 
 func (object *Property) validate() error {
-    return nil
+    if object.Units <= 0 {return newError(ERR_INVALID_ARGUMENT, "A property must be issued with a positive number of units")}
+    if object.Valuation <= 0 {return newError(ERR_INVALID_ARGUMENT, "A property must have a positive valuation")}
+
+    rules := TradingRules{TickSize: object.TickSize, MinLot: object.MinLot}
+    return rules.validate()
+}
+
+func (object *Property) getID() string {return object.ID}
+func (object *Property) getVersion() int {return object.Version}
+func (object *Property) setVersion(version int) {object.Version = version}
+func (object *Property) markDeleted() {object.Status = PROPERTY_STATE_RECLAIMED}
+
+//closed - reclaimed and sold properties never trade again
+func (object *Property) closed() bool {
+    return object.Status == PROPERTY_STATE_RECLAIMED || object.Status == PROPERTY_STATE_SOLD
+}
+
+func (object *Property) indexes() map[string][]string {
+    if object.ManagedBy == "" {return map[string][]string{"issuer": {indexValue(object.Issuer)}}}
+    return map[string][]string{"issuer": {indexValue(object.Issuer)}, "manager": {indexValue(object.ManagedBy)}}
 }
 
 //==============================================================================================================================
@@ -674,13 +969,8 @@ func (object *Property) validate() error {
 //==============================================================================================================================
 func getAccount(stub *shim.ChaincodeStub, id string) (Account, error) {
     var object Account
-    bytes, err := stub.GetState(ACCOUNT_PREFIX + id)
-    if checkErrors(err){return object, errors.New("Couldn't retrieve account for " + id)}
-
-    object, err = unmarshalAccount(bytes)
-    if checkErrors(err){return object, err}
-
-    return object, nil
+    err := accounts.get(stub, id, &object)
+    return object, err
 }
 
 func getAccountTrades(stub *shim.ChaincodeStub, accountID string) ([]Trade, error) {
@@ -690,45 +980,21 @@ func getAccountTrades(stub *shim.ChaincodeStub, accountID string) ([]Trade, erro
 }
 
 func (object *Account) getTrades(stub *shim.ChaincodeStub) ([]Trade, error) {
-    var trades []Trade
-    var tradeMap map[string]Trade
-    if object.ID == "" {return trades, errors.New("Need an account ID to search on")}
-
-    bytes, err := stub.GetState(ACCT_TRADES_PREFIX + object.ID)
-    if checkErrors(err){return trades, errors.New("Couldn't retrieve trades for " + object.ID)}
-    if bytes != nil && len(bytes) > 0 {
-        tradeMapObj, err := unmarshalTradeMap(bytes)
-        if checkErrors(err){return trades, err}
-        tradeMap = tradeMapObj.Trades
-    } else {
-        tradeMap = map[string]Trade{}
-    }
-
-    for _, value := range tradeMap {
-        trades = append(trades, value)
-    }
-
-    return trades, nil
+    if object.ID == "" {return []Trade{}, newError(ERR_INVALID_ARGUMENT, "Need an account ID to search on")}
+    return getIndexedTrades(stub, "account", object.ID)
 }
 
 func (object *Account) create(stub *shim.ChaincodeStub) error {
     err := object.validate()
     if checkErrors(err){return err}
 
-    if object.ID == "" {return errors.New("An account needs to be assigned to an owner")}
-    if object.exists(stub){return errors.New("This account already exists")}
+    if object.ID == "" {return newError(ERR_INVALID_ARGUMENT, "An account needs to be assigned to an owner")}
 
-    return object.save(stub)
+    return accounts.create(stub, object)
 }
 
 func (object *Account) save(stub *shim.ChaincodeStub) error {
-    bytes, err := object.marshal()
-    if checkErrors(err){return err}
-    
-    err = stub.PutState(ACCOUNT_PREFIX + object.ID, bytes)
-    if checkErrors(err){return errors.New("Couldn't save account for " + object.ID)}
-
-    return nil
+    return accounts.save(stub, object)
 }
 
 func deleteAccount(stub *shim.ChaincodeStub, id string) error {
@@ -739,132 +1005,468 @@ func deleteAccount(stub *shim.ChaincodeStub, id string) error {
 }
 
 func (object *Account) delete(stub *shim.ChaincodeStub) error {
-    object.Status = ACCOUNT_STATE_INACTIVE
-    err := object.save(stub)
-    if checkErrors(err){return errors.New("Couldn't delete account for " + object.ID)}
-
-    return nil
+    return accounts.delete(stub, object)
 }
 
 func (object *Account) exists(stub *shim.ChaincodeStub) bool {
-    bytes, err := stub.GetState(ACCOUNT_PREFIX + object.ID)
-    return bytes != nil || err != nil
+    found, err := accounts.exists(stub, object.ID)
+    return found || err != nil
+}
+
+//checkNotProperty - a property's own account never trades or moves cash to or from the bank. Accounts created before
+//                   ROLE_PROPERTY existed are recognised by sharing their ID with a property
+func checkNotProperty(stub *shim.ChaincodeStub, account Account) error {
+    if account.Role != ROLE_PROPERTY {
+        found, err := properties.exists(stub, account.ID)
+        if checkErrors(err){return err}
+        if !found {return nil}
+    }
+    return newError(ERR_UNAUTHORISED, "Account " + account.ID + " belongs to a property and can't do this")
 }
 
 func (object *Account) validate() error {
     return nil
 }
 
+func (object *Account) getID() string {return object.ID}
+func (object *Account) getVersion() int {return object.Version}
+func (object *Account) setVersion(version int) {object.Version = version}
+func (object *Account) markDeleted() {object.Status = ACCOUNT_STATE_INACTIVE}
+
 func (object *Account) changeHolding(entity string, unitsDelta int) error {
-    var holding Holding
-    var found bool
-    for i := 0; i < len(object.Holdings) && !found; i++ {
+    index := -1
+    for i := 0; i < len(object.Holdings) && index < 0; i++ {
 		if object.Holdings[i].Entity == entity {
-            holding = object.Holdings[i]
-            found = true
+            index = i
         }
 	}
 
-    if !found {
-        holding.Entity = entity
-        object.Holdings = append(object.Holdings, holding)
-    }
-    
     var finalUnits int
-    finalUnits = holding.Units + unitsDelta
+    if index >= 0 {finalUnits = object.Holdings[index].Units}
+    finalUnits += unitsDelta
     if (finalUnits < 0) {
-        return errors.New("There are not enough units to make this trade")
+        return newError(ERR_INSUFFICIENT_UNITS, "There are not enough units to make this trade")
     }
 
-    holding.Units = finalUnits
+    //update the holding in place, dropping it once it is empty
+    if index < 0 {
+        if finalUnits > 0 {object.Holdings = append(object.Holdings, Holding{Entity: entity, Units: finalUnits})}
+    } else if finalUnits == 0 {
+        object.Holdings = append(object.Holdings[:index], object.Holdings[index+1:]...)
+    } else {
+        object.Holdings[index].Units = finalUnits
+    }
 
     return nil
 }
 
+func (object *Account) getHolding(entity string) int {
+    for i := 0; i < len(object.Holdings); i++ {
+        if object.Holdings[i].Entity == entity {return object.Holdings[i].Units}
+    }
+    return 0
+}
+
 
 //==============================================================================================================================
 //     Trade
 //==============================================================================================================================
+//getIndexedTrades - the open trades filed under an account or property, in trade ID order
+func getIndexedTrades(stub *shim.ChaincodeStub, index string, value string) ([]Trade, error) {
+    objects := []Trade{}
 
+    ids, err := trades.list(stub, index, value)
+    if checkErrors(err){return objects, err}
 
-//==============================================================================================================================
-//     Parsing Subroutines
-//==============================================================================================================================
-//     Property
-//==============================================================================================================================
-func unmarshalProperty(bytes []byte) (Property, error) {
-    var object Property
-    err := json.Unmarshal(bytes, &object)
-    if checkErrors(err){return object, errors.New("Error unmarshalling property")}
-    return object, nil
-}
+    for i := 0; i < len(ids); i++ {
+        var object Trade
+        err = trades.get(stub, ids[i], &object)
+        if checkErrors(err){return objects, err}
+        objects = append(objects, object)
+    }
 
-func marshalProperties(objects []Property) ([]byte, error) {
-    bytes, err := json.Marshal(objects)
-    if checkErrors(err){return nil, errors.New("Error marshalling property array")}
-    return bytes, nil
+    return objects, nil
 }
 
-func (object *Property) marshal() ([]byte, error) {
-    bytes, err := json.Marshal(object)
-    if checkErrors(err){return nil, errors.New("Error marshalling property")}
-    return bytes, nil
-}
+//create - validates the trade and escrows the buyer's cash or the seller's units. A market buy doesn't know its
+//         price yet so the matching engine escrows it from the book. The trade itself is only saved by the matching
+//         engine if it isn't completely filled. A trade outside the price band comes back cancelled when the band
+//         halts the property
+func (object *Trade) create(stub *shim.ChaincodeStub) error {
+    if object.OrderType == "" {object.OrderType = ORDER_LIMIT}
+    if object.TimeInForce == "" {
+        object.TimeInForce = TIF_GTC
+        if object.marketable() {object.TimeInForce = TIF_IOC}
+    }
 
-func (object *TradingProperties) marshal() ([]byte, error) {
-    bytes, err := json.Marshal(object)
-    if checkErrors(err){return nil, errors.New("Error marshalling trading properties")}
-    return bytes, nil
-}
+    err := object.validate()
+    if checkErrors(err){return err}
 
-//==============================================================================================================================
-//     Account
-//==============================================================================================================================
-func unmarshalAccount(bytes []byte) (Account, error) {
-    var object Account
-    err := json.Unmarshal(bytes, &object)
-    if checkErrors(err){return object, errors.New("Error unmarshalling account")}
-    return object, nil
-}
+    if object.ID != "" {return newError(ERR_INVALID_ARGUMENT, "Can't create trade with ID already assigned")}
 
-func marshalAccounts(objects []Account) ([]byte, error) {
-    bytes, err := json.Marshal(objects)
-    if checkErrors(err){return nil, errors.New("Error marshalling account array")}
-    return bytes, nil
-}
+    account, err := getAccount(stub, object.AccountID)
+    if checkErrors(err){return err}
+    if account.Status != ACCOUNT_STATE_ACTIVE {return newError(ERR_STATE_VIOLATION, "Account " + account.ID + " is not active")}
+    err = checkNotProperty(stub, account)
+    if checkErrors(err){return err}
 
-func (object *Account) marshal() ([]byte, error) {
-    bytes, err := json.Marshal(object)
-    if checkErrors(err){return nil, errors.New("Error marshalling account")}
-    return bytes, nil
-}
+    property, err := getProperty(stub, object.PropertyID)
+    if checkErrors(err){return err}
+    if property.closed() {return newError(ERR_STATE_VIOLATION, "Property " + property.ID + " is no longer trading")}
 
-//==============================================================================================================================
-//     Trades
-//==============================================================================================================================
-func unmarshalTradeMap(bytes []byte) (TradeMap, error) {
-    var object TradeMap
-    err := json.Unmarshal(bytes, &object)
-    if checkErrors(err){return object, errors.New("Error unmarshalling trade map")}
-    return object, nil
+    object.Created, err = getTxTime(stub)
+    if checkErrors(err){return err}
+    //an auction or triggered stops can create several trades on the same side in one transaction, so count them
+    for i := 0; object.ID == ""; i++ {
+        id := getMd5Hash(getTxID(stub) + object.AccountID + object.PropertyID + object.Direction + strconv.Itoa(i))
+        found, err := trades.exists(stub, id)
+        if checkErrors(err){return err}
+        if !found {object.ID = id}
+    }
+    object.Version = 0
+    if object.TimeInForce == TIF_GTD && object.Expiry <= object.Created {return newError(ERR_INVALID_ARGUMENT, "A GTD trade must expire in the future")}
+
+    object.Status = TRADE_STATE_OPEN
+    if object.OrderType == ORDER_STOP || object.OrderType == ORDER_STOP_LIMIT {object.Status = TRADE_STATE_DORMANT}
+
+    err = config.checkTrade(*object, property)
+    if checkErrors(err){return err}
+    err = property.checkTrade(*object)
+    if checkErrors(err){return err}
+
+    market, err := getMarket(stub)
+    if checkErrors(err){return err}
+    err = market.checkTrade(*object, property)
+    if checkErrors(err){return err}
+
+    //an error would undo the halt so a trade that halts the property is cancelled instead
+    band := config.PriceBand
+    if !property.inBand(band, object.orderPrice()) {
+        if band.HaltSeconds == 0 {return newError(ERR_INVALID_ARGUMENT, "Price is outside " + fmt.Sprint(band.Pct) + "% of the reference price " + fmt.Sprint(property.referencePrice()))}
+
+        log.warn("Halted property on a price outside the band", "propertyID", property.ID, "tradeID", object.ID, "price", object.orderPrice())
+        object.Status = TRADE_STATE_CANCELLED
+        property.HaltedUntil = object.Created + band.HaltSeconds
+        return property.save(stub)
+    }
+
+    if object.Direction == TRADE_BUY {
+        err = checkHoldingLimit(stub, account, object.PropertyID, object.Units)
+        if checkErrors(err){return err}
+    }
+    err = checkOpenNotionalLimit(stub, account, object.valuePrice(property) * float64(object.Units))
+    if checkErrors(err){return err}
+
+    if object.Direction == TRADE_BUY && object.marketable() {
+        object.Escrow = 0
+    } else if object.Direction == TRADE_BUY {
+        schedule := config.Fees
+
+        //escrow enough to pay the limit price plus the highest fee we could charge
+        notional := object.Price * float64(object.Units)
+        object.Escrow = notional + schedule.maxTradeFee(notional, account.Role)
+        if account.Cash < object.Escrow {return newError(ERR_INSUFFICIENT_FUNDS, "Not enough cash to make this trade")}
+        account.Cash -= object.Escrow
+    } else {
+        object.Escrow = 0
+        err = account.changeHolding(object.PropertyID, -object.Units)
+        if checkErrors(err){return err}
+    }
+
+    return account.save(stub)
+}
+
+//getTradeMapBlobs - every old trade map stored under the prefix, read in full before any are deleted
+func getTradeMapBlobs(stub *shim.ChaincodeStub, prefix string) ([]string, []TradeMap, error) {
+    var keys []string
+    var tradeMaps []TradeMap
+
+    iterator, err := stub.RangeQueryState(prefix, rangeEnd(prefix))
+    if checkErrors(err){return nil, nil, wrapError(ERR_INTERNAL, "Couldn't retrieve trade maps", err)}
+    defer iterator.Close()
+
+    for iterator.HasNext() {
+        key, bytes, err := iterator.Next()
+        if checkErrors(err){return nil, nil, wrapError(ERR_INTERNAL, "Couldn't retrieve trade maps", err)}
+
+        tradeMap, err := unmarshalTradeMap(bytes)
+        if checkErrors(err){return nil, nil, err}
+        keys = append(keys, key)
+        tradeMaps = append(tradeMaps, tradeMap)
+    }
+
+    return keys, tradeMaps, nil
+}
+
+func (object *Trade) save(stub *shim.ChaincodeStub) error {
+    err := trades.save(stub, object)
+    if checkErrors(err){return err}
+
+    return addTradingProperty(stub, object.PropertyID)
+}
+
+func (object *Trade) remove(stub *shim.ChaincodeStub) error {
+    return trades.delete(stub, object)
+}
+
+func (object *Trade) validate() error {
+    if object.AccountID == "" {return newError(ERR_INVALID_ARGUMENT, "A trade needs an account")}
+    if object.PropertyID == "" {return newError(ERR_INVALID_ARGUMENT, "A trade needs a property")}
+    if object.Direction != TRADE_BUY && object.Direction != TRADE_SELL {return newError(ERR_INVALID_ARGUMENT, "Invalid trade direction " + object.Direction)}
+    if object.Units <= 0 {return newError(ERR_INVALID_ARGUMENT, "A trade must be for a positive number of units")}
+
+    switch object.OrderType {
+        case ORDER_LIMIT, ORDER_STOP_LIMIT:
+            if object.Price <= 0 {return newError(ERR_INVALID_ARGUMENT, "A trade must have a positive price")}
+        case ORDER_MARKET, ORDER_STOP:
+            if object.Price != 0 {return newError(ERR_INVALID_ARGUMENT, "A market trade can't have a price")}
+        default:
+            return newError(ERR_INVALID_ARGUMENT, "Invalid order type " + object.OrderType)
+    }
+
+    stop := object.OrderType == ORDER_STOP || object.OrderType == ORDER_STOP_LIMIT
+    if stop && object.StopPrice <= 0 {return newError(ERR_INVALID_ARGUMENT, "A stop trade must have a positive stop price")}
+    if !stop && object.StopPrice != 0 {return newError(ERR_INVALID_ARGUMENT, "Only stop trades have a stop price")}
+
+    switch object.TimeInForce {
+        case TIF_IOC, TIF_FOK:
+        case TIF_GTC, TIF_GTD:
+            if object.marketable() {return newError(ERR_INVALID_ARGUMENT, "A market trade must be IOC or FOK")}
+        default:
+            return newError(ERR_INVALID_ARGUMENT, "Invalid time in force " + object.TimeInForce)
+    }
+    if object.TimeInForce != TIF_GTD && object.Expiry != 0 {return newError(ERR_INVALID_ARGUMENT, "Only GTD trades have an expiry")}
+    if object.SelfTradePrevention != "" && !validSelfTradePrevention(object.SelfTradePrevention) {return newError(ERR_INVALID_ARGUMENT, "Invalid self trade prevention " + object.SelfTradePrevention)}
+
+    return nil
+}
+
+//marketable - trades at whatever price the book offers, once triggered in the case of a stop
+func (object *Trade) marketable() bool {
+    return object.OrderType == ORDER_MARKET || object.OrderType == ORDER_STOP
+}
+
+//stopTriggered - a buy stop triggers when the last price rises to the stop price, a sell stop when it falls to it
+func (object *Trade) stopTriggered(lastPrice float64) bool {
+    if lastPrice <= 0 {return false}
+    if object.Direction == TRADE_BUY {return lastPrice >= object.StopPrice}
+    return lastPrice <= object.StopPrice
+}
+
+//orderPrice - what the trade is valued at while it is open, a stop that will trade at market uses its stop price
+func (object *Trade) orderPrice() float64 {
+    if object.Price > 0 {return object.Price}
+    return object.StopPrice
+}
+
+//valuePrice - the price the trade's value is limited at. A market trade has no price of its own so it is valued at the
+//             property's reference price
+func (object *Trade) valuePrice(property Property) float64 {
+    if object.orderPrice() > 0 {return object.orderPrice()}
+    return property.referencePrice()
+}
+
+func (object *Trade) expired(now int64) bool {
+    return object.TimeInForce == TIF_GTD && object.Expiry <= now
+}
+
+//cancel - give back whatever is still escrowed and take the trade off the book if it was ever saved
+func (object *Trade) cancel(stub *shim.ChaincodeStub, status int) error {
+    account, err := getAccount(stub, object.AccountID)
+    if checkErrors(err){return err}
+
+    if object.Direction == TRADE_BUY {
+        account.Cash += object.Escrow
+        object.Escrow = 0
+    } else {
+        err = account.changeHolding(object.PropertyID, object.Units)
+        if checkErrors(err){return err}
+    }
+    err = account.save(stub)
+    if checkErrors(err){return err}
+
+    object.Status = status
+    if object.Version > 0 {return object.remove(stub)}
+    return nil
+}
+
+func (object *Trade) getID() string {return object.ID}
+func (object *Trade) getVersion() int {return object.Version}
+func (object *Trade) setVersion(version int) {object.Version = version}
+
+func (object *Trade) indexes() map[string][]string {
+    return map[string][]string{"account": {indexValue(object.AccountID)}, "property": {indexValue(object.PropertyID)}}
+}
+
+//crosses - true if this trade can be matched against the other (resting) trade
+func (object *Trade) crosses(other Trade) bool {
+    if object.Direction == other.Direction {return false}
+    if object.marketable() {return true}
+    if object.Direction == TRADE_BUY {return object.Price >= other.Price}
+    return object.Price <= other.Price
+}
+
+func addTradingProperty(stub *shim.ChaincodeStub, propertyID string) error {
+    tradingProperties, err := getTradingPropertiesIndex(stub)
+    if checkErrors(err){return err}
+
+    if _, found := tradingProperties.PropertyIDs[propertyID]; found {return nil}
+    tradingProperties.PropertyIDs[propertyID] = propertyID
+
+    bytes, err := tradingProperties.marshal()
+    if checkErrors(err){return err}
+
+    err = stub.PutState(TRDING_PRPTY_PREFIX, bytes)
+    if checkErrors(err){return wrapError(ERR_INTERNAL, "Couldn't save trading properties", err)}
+
+    return nil
+}
+
+//==============================================================================================================================
+//     Offer
+//==============================================================================================================================
+func getOffer(stub *shim.ChaincodeStub, id string) (Offer, error) {
+    var object Offer
+    err := offers.get(stub, id, &object)
+    return object, err
+}
+
+func (object *Offer) create(stub *shim.ChaincodeStub) error {
+    err := object.validate()
+    if checkErrors(err){return err}
+
+    if object.ID != "" {return newError(ERR_INVALID_ARGUMENT, "Can't create offer with ID already assigned")}
+    object.ID = getMd5Hash(getTxID(stub) + object.PropertyID + object.Seller + strconv.Itoa(object.Units) + object.Buyer)
+
+    return offers.create(stub, object)
+}
+
+func (object *Offer) save(stub *shim.ChaincodeStub) error {
+    return offers.save(stub, object)
+}
+
+func (object *Offer) delete(stub *shim.ChaincodeStub) error {
+    return offers.delete(stub, object)
+}
+
+func (object *Offer) validate() error {
+    if object.Seller == "" {return newError(ERR_INVALID_ARGUMENT, "An offer needs a seller")}
+    if object.Units <= 0 {return newError(ERR_INVALID_ARGUMENT, "An offer must be for a positive number of units")}
+    if object.Price <= 0 || math.IsNaN(object.Price) || math.IsInf(object.Price, 0) {return newError(ERR_INVALID_ARGUMENT, "An offer must have a positive price")}
+    return nil
+}
+
+func (object *Offer) getID() string {return object.ID}
+func (object *Offer) getVersion() int {return object.Version}
+func (object *Offer) setVersion(version int) {object.Version = version}
+
+func (object *Offer) indexes() map[string][]string {
+    return map[string][]string{"property": {indexValue(object.PropertyID)}}
+}
+
+//==============================================================================================================================
+//     Execution
+//==============================================================================================================================
+func (object *Execution) save(stub *shim.ChaincodeStub) error {
+    return executions.create(stub, object)
+}
+
+func (object *Execution) getID() string {return object.ID}
+func (object *Execution) getVersion() int {return object.Version}
+func (object *Execution) setVersion(version int) {object.Version = version}
+
+//indexes - by property, and by both sides of the fill
+func (object *Execution) indexes() map[string][]string {
+    accountIDs := []string{indexValue(object.BuyerID)}
+    if object.SellerID != object.BuyerID {accountIDs = append(accountIDs, indexValue(object.SellerID))}
+    return map[string][]string{"property": {indexValue(object.PropertyID)}, "account": accountIDs}
+}
+
+//==============================================================================================================================
+//     Parsing Subroutines
+//==============================================================================================================================
+//     Property
+//==============================================================================================================================
+func marshalProperties(objects []Property) ([]byte, error) {
+    bytes, err := json.Marshal(objects)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling property array", err)}
+    return bytes, nil
+}
+
+func (object *Property) marshal() ([]byte, error) {
+    bytes, err := json.Marshal(object)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling property", err)}
+    return bytes, nil
+}
+
+func (object *TradingProperties) marshal() ([]byte, error) {
+    bytes, err := json.Marshal(object)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling trading properties", err)}
+    return bytes, nil
+}
+
+//==============================================================================================================================
+//     Account
+//==============================================================================================================================
+func marshalAccounts(objects []Account) ([]byte, error) {
+    bytes, err := json.Marshal(objects)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling account array", err)}
+    return bytes, nil
+}
+
+func (object *Account) marshal() ([]byte, error) {
+    bytes, err := json.Marshal(object)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling account", err)}
+    return bytes, nil
+}
+
+//==============================================================================================================================
+//     Trades
+//==============================================================================================================================
+func unmarshalTradeMap(bytes []byte) (TradeMap, error) {
+    var object TradeMap
+    err := json.Unmarshal(bytes, &object)
+    if checkErrors(err){return object, wrapError(ERR_INTERNAL, "Error unmarshalling trade map", err)}
+    return object, nil
+}
+
+func (object *TradeResult) marshal() ([]byte, error) {
+    bytes, err := json.Marshal(object)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling trade result", err)}
+    return bytes, nil
+}
+
+func (object *Execution) marshal() ([]byte, error) {
+    bytes, err := json.Marshal(object)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling execution", err)}
+    return bytes, nil
 }
 
 func unmarshalTradingProperties(bytes []byte) (TradingProperties, error) {
     var object TradingProperties
     err := json.Unmarshal(bytes, &object)
-    if checkErrors(err){return object, errors.New("Error unmarshalling trading properties")}
+    if checkErrors(err){return object, wrapError(ERR_INTERNAL, "Error unmarshalling trading properties", err)}
     return object, nil
 }
 
 func marshalTrades(objects []Trade) ([]byte, error) {
     bytes, err := json.Marshal(objects)
-    if checkErrors(err){return nil, errors.New("Error marshalling trade array")}
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling trade array", err)}
     return bytes, nil
 }
 
 func marshalReturnTrades(objects []ReturnTrade) ([]byte, error) {
     bytes, err := json.Marshal(objects)
-    if checkErrors(err){return nil, errors.New("Error marshalling return trade array")}
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling return trade array", err)}
+    return bytes, nil
+}
+
+//==============================================================================================================================
+//     Offer
+//==============================================================================================================================
+func (object *Offer) marshal() ([]byte, error) {
+    bytes, err := json.Marshal(object)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling offer", err)}
     return bytes, nil
 }
 
@@ -873,64 +1475,65 @@ func marshalReturnTrades(objects []ReturnTrade) ([]byte, error) {
 //==============================================================================================================================
 func marshalStringArray(objects []string) ([]byte, error) {
     bytes, err := json.Marshal(objects)
-    if checkErrors(err){return nil, errors.New("Error marshalling trade array")}
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling trade array", err)}
     return bytes, nil
 }
 
 //==============================================================================================================================
 //     Utility Subroutines
 //==============================================================================================================================
-//     Configure
+//     Configure - Save the initial configuration and create the admin account that can change it later
 //==============================================================================================================================
-func configure(args []string) error {
-    err := json.Unmarshal([]byte(args[0]), &config)
-    if checkErrors(err){return errors.New("Error unmarshalling configuration")}
-    return nil
-}
+func configure(stub *shim.ChaincodeStub, args []string) error {
+    //configure(config string, adminAccountID string)
+    if len(args) != 2 {return newError(ERR_INVALID_ARGUMENT, "Incorrect number of arguments passed")}
 
-//==============================================================================================================================
-//     Logging
-//==============================================================================================================================
-func (l *Log) debug(text string) {
-    l.log(LOG_DEBUG, text)
-}
+    configuration, err := unmarshalConfiguration([]byte(args[0]))
+    if checkErrors(err){return wrapError(ERR_INVALID_ARGUMENT, "Invalid configuration", err)}
 
-func (l *Log) info(text string) {
-    l.log(LOG_INFO, text)
-}
+    err = configuration.validate()
+    if checkErrors(err){return err}
+
+    var admin Account
+    admin.ID = args[1]
+    admin.Role = ROLE_ADMIN
+    admin.Status = ACCOUNT_STATE_ACTIVE
+    err = admin.create(stub)
+    if checkErrors(err){return err}
 
-func (l *Log) warn(text string) {
-    l.log(LOG_WARN, text)
+    err = configuration.save(stub)
+    if checkErrors(err){return err}
+    config = configuration
+
+    return nil
 }
 
-func (l *Log) error(text string) {
-    l.log(LOG_ERROR, text)
+//==============================================================================================================================
+//     checkErrors - Standard error checking code
+//==============================================================================================================================
+func checkErrors(err error) bool {
+    return err != nil
 }
 
-func (l *Log) log(logLevel int, text string) {
-    var prefix string
-    switch logLevel {
-        case LOG_DEBUG:
-            prefix = "DEBUG: "
-        case LOG_INFO:
-            prefix = "INFO:  "
-        case LOG_WARN:
-            prefix = "WARN:  "
-        case LOG_ERROR:
-            prefix = "ERROR: "
-    }
-    if (l.shouldLog(logLevel)) {fmt.Println(prefix + text)}
+//==============================================================================================================================
+//     getTxID / getTxTime - The transaction's ID and timestamp (in seconds). Use these rather than the system clock so
+//                           every peer computes the same result
+//==============================================================================================================================
+func getTxID(stub *shim.ChaincodeStub) string {
+    return stub.UUID
 }
 
-func (l *Log) shouldLog(logLevel int) bool {
-    return logLevel >= config.logLevel
+func getTxTime(stub *shim.ChaincodeStub) (int64, error) {
+    timestamp, err := stub.GetTxTimestamp()
+    if checkErrors(err){return 0, wrapError(ERR_INTERNAL, "Couldn't read the transaction timestamp", err)}
+    return timestamp.Seconds, nil
 }
 
 //==============================================================================================================================
-//     checkErrors - Standard error checking code
+//     roundCents - Rounds a cash value to the nearest cent
 //==============================================================================================================================
-func checkErrors(err error) bool {
-    return err != nil
+func roundCents(value float64) float64 {
+    return math.Floor(value * 100 + 0.5) / 100
 }
 
 //==============================================================================================================================
@@ -953,8 +1556,9 @@ func (t *SimpleChaincode ) get_user_data(stub *shim.ChaincodeStub, name string)
     //get the ecert
     ecert, err := t.get_ecert(stub, name);
     if err != nil {
-        return nil, -1, errors.New("Could not find ecert for user: "+name)
+        return nil, -1, wrapError(ERR_UNAUTHORISED, "Could not find ecert for user: "+name, err)
     }
+    log.debug("retrieved ecert", "user", name, "ecert", string(ecert))
 
     //get the role
     role, err := t.check_role(stub,[]string{string(ecert)});
@@ -965,6 +1569,23 @@ func (t *SimpleChaincode ) get_user_data(stub *shim.ChaincodeStub, name string)
     return ecert, role, nil
 }
 
+//==============================================================================================================================
+//     checkAccountRole - Gets the account and makes sure it holds one of the roles allowed to perform the action
+//==============================================================================================================================
+func checkAccountRole(stub *shim.ChaincodeStub, accountID string, roles ...int) (Account, error) {
+    account, err := getAccount(stub, accountID)
+    if checkErrors(err){return account, err}
+
+    var authorised bool
+    for i := 0; i < len(roles); i++ {
+        if account.Role == roles[i] {authorised = true}
+    }
+    if !authorised {return account, newError(ERR_UNAUTHORISED, "Account " + accountID + " is not authorised to perform this action")}
+    if account.Status != ACCOUNT_STATE_ACTIVE {return account, newError(ERR_STATE_VIOLATION, "Account " + accountID + " is not active")}
+
+    return account, nil
+}
+
 //==============================================================================================================================
 //     check_role - Takes an ecert, decodes it to remove html encoding then parses it and checks the
 //                   certificates extensions containing the role before returning the role interger. Returns -1 if it errors
@@ -974,7 +1595,7 @@ func (t *SimpleChaincode ) check_role(stub *shim.ChaincodeStub, args []string) (
 
     //make % etc normal
     decodedCert, err := url.QueryUnescape(args[0]);
-    if err != nil { return -1, errors.New("Could not decode certificate") }
+    if err != nil { return -1, wrapError(ERR_UNAUTHORISED, "Could not decode certificate", err) }
 
     //make plain text
     pem, _ := pem.Decode([]byte(decodedCert))
@@ -982,7 +1603,7 @@ func (t *SimpleChaincode ) check_role(stub *shim.ChaincodeStub, args []string) (
     //extract certificate from argument
     x509Cert, err := x509.ParseCertificate(pem.Bytes);
     if err != nil {
-        return -1, errors.New("Couldn't parse certificate")
+        return -1, wrapError(ERR_UNAUTHORISED, "Couldn't parse certificate", err)
     }
 
     //get role out of certificate and return it
@@ -991,7 +1612,7 @@ func (t *SimpleChaincode ) check_role(stub *shim.ChaincodeStub, args []string) (
         if reflect.DeepEqual(ext.Id, ECertSubjectRole) {
             role, err = strconv.ParseInt(string(ext.Value), 10, len(ext.Value)*8)   
             if err != nil {
-                return -1, errors.New("Failed parsing role: " + err.Error())
+                return -1, wrapError(ERR_UNAUTHORISED, "Failed parsing role", err)
             }
             break
         }
@@ -1008,14 +1629,14 @@ func (t *SimpleChaincode ) get_user(stub *shim.ChaincodeStub, encodedCert string
     //make % etc normal 
     decodedCert, err := url.QueryUnescape(encodedCert);
     if err != nil {
-        return "", errors.New("Could not decode certificate")
+        return "", wrapError(ERR_UNAUTHORISED, "Could not decode certificate", err)
     }
 
     //make plain text
     pem, _ := pem.Decode([]byte(decodedCert))
     x509Cert, err := x509.ParseCertificate(pem.Bytes);
     if err != nil {
-        return "", errors.New("Couldn't parse certificate")
+        return "", wrapError(ERR_UNAUTHORISED, "Couldn't parse certificate", err)
     }
 
     //return the user from the certificate
@@ -1032,7 +1653,7 @@ func (t *SimpleChaincode ) get_ecert(stub *shim.ChaincodeStub, name string) ([]b
     //call out to the hyperLedger rest api to get the ecert of the user with that name
     response, err := http.Get("BLC_API_URL/registrar/"+name+"/ecert")
     if err != nil {
-        return nil, errors.New("Could not get ecert")
+        return nil, wrapError(ERR_UNAUTHORISED, "Could not get ecert", err)
     }
 
     //use the defer construct to close the stream after the method completes
@@ -1041,13 +1662,13 @@ func (t *SimpleChaincode ) get_ecert(stub *shim.ChaincodeStub, name string) ([]b
     //read the response from the http callout into the variable contents
     contents, err := ioutil.ReadAll(response.Body)
     if err != nil {
-        return nil, errors.New("Could not read body")
+        return nil, wrapError(ERR_INTERNAL, "Could not read body", err)
     }
 
     //unmarshall the contents of the certificate
     err = json.Unmarshal(contents, &cert)
     if err != nil {
-        return nil, errors.New("ECert not found for user: "+name)
+        return nil, wrapError(ERR_UNAUTHORISED, "ECert not found for user: "+name, err)
     }
 
     return []byte(string(cert.OK)), nil
@@ -1063,7 +1684,7 @@ func (t *SimpleChaincode ) testAccountCreateSuccess(stub *shim.ChaincodeStub, ac
     var account Account
     account.ID = accountID
     err := account.create(stub)
-    if checkErrors(err) {
+    if !checkErrors(err) {
         responses = append(responses, "COMPLETE: Account created without errors")
     } else {
         responses = append(responses, "FAIL: call to create account failed")
@@ -1071,3 +1692,852 @@ func (t *SimpleChaincode ) testAccountCreateSuccess(stub *shim.ChaincodeStub, ac
     return responses
 }
 
+//testFreshLedgerQueries - run before anything else has been written
+func (t *SimpleChaincode ) testFreshLedgerQueries(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    bytes, err := t.dispatch(stub, FUNCTION_QUERY, "getAvailableTrades", []string{})
+    if !checkErrors(err) && string(bytes) == "[]" {
+        responses = append(responses, "COMPLETE: No available trades on a fresh ledger")
+    } else {
+        responses = append(responses, "FAIL: available trades on a fresh ledger should be empty")
+    }
+
+    _, err = getAccount(stub, "testmissingaccount")
+    if isNotFound(err) {
+        responses = append(responses, "COMPLETE: Missing account is not found")
+    } else {
+        responses = append(responses, "FAIL: missing account should be not found")
+    }
+
+    _, err = getProperty(stub, "testmissingproperty")
+    if isNotFound(err) {
+        responses = append(responses, "COMPLETE: Missing property is not found")
+    } else {
+        responses = append(responses, "FAIL: missing property should be not found")
+    }
+
+    _, err = getOffer(stub, "testmissingoffer")
+    if isNotFound(err) {
+        responses = append(responses, "COMPLETE: Missing offer is not found")
+    } else {
+        responses = append(responses, "FAIL: missing offer should be not found")
+    }
+
+    trades, err := getPropertyTrades(stub, "testmissingproperty")
+    if !checkErrors(err) && len(trades) == 0 {
+        responses = append(responses, "COMPLETE: No trades for a property that was never traded")
+    } else {
+        responses = append(responses, "FAIL: trades for a property that was never traded should be empty")
+    }
+    return responses
+}
+
+func (t *SimpleChaincode ) testFeeScheduleDiscount() []string {
+    var responses []string
+
+    var schedule FeeSchedule
+    schedule.MakerBps = 10
+    schedule.TakerBps = 50
+    schedule.RoleDiscounts = map[string]int{strconv.Itoa(ROLE_MARKET_MAKER): 5000}
+
+    if schedule.tradeFee(1000, ROLE_MARKET_MAKER, false) == 2.5 && schedule.tradeFee(1000, ROLE_PRIVATE_ENTITY, true) == 1 {
+        responses = append(responses, "COMPLETE: Trade fees discounted by role")
+    } else {
+        responses = append(responses, "FAIL: trade fees weren't discounted by role")
+    }
+    return responses
+}
+
+//testRepositoryVersioning - a stale copy can't overwrite a newer save, and index keys follow the indexed values
+func (t *SimpleChaincode ) testRepositoryVersioning(stub *shim.ChaincodeStub, accountID string) []string {
+    var responses []string
+
+    first, err := getAccount(stub, accountID)
+    stale, err2 := getAccount(stub, accountID)
+    first.Cash = 10
+    err3 := first.save(stub)
+    stale.Cash = 20
+    err4 := stale.save(stub)
+    if !checkErrors(err) && !checkErrors(err2) && !checkErrors(err3) && errorCode(err4) == ERR_CONFLICT {
+        responses = append(responses, "COMPLETE: Saving a stale account is a conflict")
+    } else {
+        responses = append(responses, "FAIL: saving a stale account should be a conflict")
+    }
+
+    var offer Offer
+    offer.Seller = accountID
+    offer.PropertyID = "testpropertya"
+    offer.Units = 1
+    offer.Price = 1
+    err = offer.create(stub)
+    offer.PropertyID = "testpropertyb"
+    err2 = offer.save(stub)
+    before, _ := offers.list(stub, "property", "testpropertya")
+    after, _ := offers.list(stub, "property", "testpropertyb")
+    err3 = offer.delete(stub)
+    deleted, _ := offers.list(stub, "property", "testpropertyb")
+    if !checkErrors(err) && !checkErrors(err2) && !checkErrors(err3) && len(before) == 0 && len(after) == 1 && len(deleted) == 0 {
+        responses = append(responses, "COMPLETE: Offer index follows its property")
+    } else {
+        responses = append(responses, "FAIL: offer index should follow its property")
+    }
+    return responses
+}
+
+//testPagination - following the bookmark walks every matching record once and the last page has no bookmark
+func (t *SimpleChaincode ) testPagination(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    for _, id := range []string{"testpagea", "testpageb", "testpagec"} {
+        account := Account{ID: id, Status: ACCOUNT_STATE_INACTIVE}
+        account.create(stub)
+    }
+
+    var first, second struct {
+        Records     []Account   `json:"records"`
+        Bookmark    string      `json:"bookmark"`
+    }
+    status := strconv.Itoa(ACCOUNT_STATE_INACTIVE)
+    bytes, err := t.dispatch(stub, FUNCTION_QUERY, "listAccounts", []string{"2", "", status})
+    json.Unmarshal(bytes, &first)
+    bytes, err2 := t.dispatch(stub, FUNCTION_QUERY, "listAccounts", []string{"2", first.Bookmark, status})
+    json.Unmarshal(bytes, &second)
+    if !checkErrors(err) && !checkErrors(err2) && len(first.Records) == 2 && first.Bookmark != "" && len(second.Records) == 1 &&
+        second.Records[0].ID == "testpagec" && second.Bookmark == "" {
+        responses = append(responses, "COMPLETE: Pages follow the bookmark to the last matching record")
+    } else {
+        responses = append(responses, "FAIL: pages should follow the bookmark to the last matching record")
+    }
+
+    _, err = t.dispatch(stub, FUNCTION_QUERY, "listTrades", []string{"", "", "", strconv.Itoa(TRADE_STATE_FILLED)})
+    _, err2 = t.dispatch(stub, FUNCTION_QUERY, "listTrades", []string{"", "", "", strconv.Itoa(TRADE_STATE_DORMANT)})
+    if errorCode(err) == ERR_INVALID_ARGUMENT && !checkErrors(err2) {
+        responses = append(responses, "COMPLETE: Trades can only be listed by the statuses kept on the book")
+    } else {
+        responses = append(responses, "FAIL: trades should only be listed by the statuses kept on the book")
+    }
+    return responses
+}
+
+//testCashRequests - cash moves only when the exchange confirms a deposit, a withdrawal is reserved until it is resolved
+//                   and a resolved request can't be resolved again
+func (t *SimpleChaincode ) testCashRequests(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    exchange := Account{ID: "testcashexchange", Role: ROLE_EXCHANGE}
+    exchange.create(stub)
+    account := Account{ID: "testcashaccount", Role: ROLE_PRIVATE_ENTITY}
+    account.create(stub)
+
+    var deposit, withdrawal CashRequest
+    bytes, err := t.dispatch(stub, FUNCTION_INVOKE, "requestDeposit", []string{account.ID, "100", "TESTDEPOSIT"})
+    json.Unmarshal(bytes, &deposit)
+    pending, _ := getAccount(stub, account.ID)
+    _, err2 := t.dispatch(stub, FUNCTION_INVOKE, "confirmCashRequest", []string{account.ID, deposit.ID})
+    _, err3 := t.dispatch(stub, FUNCTION_INVOKE, "confirmCashRequest", []string{exchange.ID, deposit.ID})
+    _, err4 := t.dispatch(stub, FUNCTION_INVOKE, "confirmCashRequest", []string{exchange.ID, deposit.ID})
+    confirmed, _ := getAccount(stub, account.ID)
+    if !checkErrors(err) && pending.Cash == 0 && errorCode(err2) == ERR_UNAUTHORISED && !checkErrors(err3) && errorCode(err4) == ERR_STATE_VIOLATION && confirmed.Cash == 100 {
+        responses = append(responses, "COMPLETE: A deposit is credited once when the exchange confirms it")
+    } else {
+        responses = append(responses, "FAIL: a deposit should be credited once when the exchange confirms it")
+    }
+
+    bytes, err = t.dispatch(stub, FUNCTION_INVOKE, "requestWithdrawal", []string{account.ID, "40", "TESTWITHDRAWAL"})
+    json.Unmarshal(bytes, &withdrawal)
+    reserved, _ := getAccount(stub, account.ID)
+    _, err2 = t.dispatch(stub, FUNCTION_INVOKE, "rejectCashRequest", []string{exchange.ID, withdrawal.ID, "test"})
+    rejected, _ := getAccount(stub, account.ID)
+    withdrawal, err3 = getCashRequest(stub, withdrawal.ID)
+    if !checkErrors(err) && !checkErrors(err2) && !checkErrors(err3) && reserved.Cash == 100 - withdrawal.Reserved && withdrawal.Reserved >= 40 &&
+        rejected.Cash == 100 && withdrawal.Status == CASH_REQUEST_REJECTED {
+        responses = append(responses, "COMPLETE: A rejected withdrawal returns the cash it reserved")
+    } else {
+        responses = append(responses, "FAIL: a rejected withdrawal should return the cash it reserved")
+    }
+    return responses
+}
+
+//testIdempotentReplay - a repeated request ID replays the first result without running again, and reusing it for a
+//                       different call is a conflict
+func (t *SimpleChaincode ) testIdempotentReplay(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    exchange := Account{ID: "testreplayexchange", Role: ROLE_EXCHANGE}
+    exchange.create(stub)
+    account := Account{ID: "testreplayaccount", Role: ROLE_PRIVATE_ENTITY}
+    account.create(stub)
+
+    _, err := t.dispatch(stub, FUNCTION_INVOKE, "depositCash", []string{exchange.ID, account.ID, "10", "testreplay"})
+    _, err2 := t.dispatch(stub, FUNCTION_INVOKE, "depositCash", []string{exchange.ID, account.ID, "10", "testreplay"})
+    _, err3 := t.dispatch(stub, FUNCTION_INVOKE, "depositCash", []string{exchange.ID, account.ID, "20", "testreplay"})
+    replayed, _ := getAccount(stub, account.ID)
+    if !checkErrors(err) && !checkErrors(err2) && errorCode(err3) == ERR_CONFLICT && replayed.Cash == 10 {
+        responses = append(responses, "COMPLETE: A repeated request is replayed and a different call with its ID is a conflict")
+    } else {
+        responses = append(responses, "FAIL: a repeated request should be replayed and a different call with its ID should be a conflict")
+    }
+    return responses
+}
+
+//testReclaimPayouts - every other holder is paid for their units out of the funder's cash and all units are taken away
+func (t *SimpleChaincode ) testReclaimPayouts(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    propertyID, err := t.testIssueProperty(stub, "1 Reclaim St", "testreclaimissuer", "", 100, []Holding{{Entity: "testreclaimb", Units: 30}, {Entity: "testreclaimc", Units: 10}})
+    _, err2 := t.dispatch(stub, FUNCTION_INVOKE, "reclaimProperty", []string{"testreclaimissuer", propertyID, "2.5"})
+    issuer, _ := getAccount(stub, "testreclaimissuer")
+    holder, _ := getAccount(stub, "testreclaimb")
+    other, _ := getAccount(stub, "testreclaimc")
+    property, _ := getProperty(stub, propertyID)
+    if !checkErrors(err) && !checkErrors(err2) && issuer.Cash == 900 && holder.Cash == 1075 && other.Cash == 1025 &&
+        issuer.getHolding(propertyID) == 0 && holder.getHolding(propertyID) == 0 && property.Status == PROPERTY_STATE_RECLAIMED {
+        responses = append(responses, "COMPLETE: Reclaiming pays every other holder for their units")
+    } else {
+        responses = append(responses, "FAIL: reclaiming should pay every other holder for their units")
+    }
+    return responses
+}
+
+//testSaleProceeds - each holder's share of the proceeds is rounded down so the residual left with the manager is never
+//                   negative
+func (t *SimpleChaincode ) testSaleProceeds(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    propertyID, err := t.testIssueProperty(stub, "1 Sale St", "testsaleissuer", "testsalemanager", 3, []Holding{{Entity: "testsaleb", Units: 1}, {Entity: "testsalec", Units: 1}})
+    bytes, err2 := t.dispatch(stub, FUNCTION_INVOKE, "settlePropertySale", []string{"testsalemanager", propertyID, "200", "0"})
+    var statement SaleStatement
+    json.Unmarshal(bytes, &statement)
+    manager, _ := getAccount(stub, "testsalemanager")
+    holder, _ := getAccount(stub, "testsaleb")
+    if !checkErrors(err) && !checkErrors(err2) && statement.Distributed == 199.98 && statement.Residual == 0.02 && manager.Cash == 800.02 &&
+        holder.Cash == 1066.66 && holder.getHolding(propertyID) == 0 {
+        responses = append(responses, "COMPLETE: Sale proceeds are paid pro rata and the rounding residual stays with the manager")
+    } else {
+        responses = append(responses, "FAIL: sale proceeds should be paid pro rata and the rounding residual should stay with the manager")
+    }
+    return responses
+}
+
+//testSplitCashInLieu - a consolidation pays cash in lieu of the fraction each holder loses, leaves a holder's escrowed
+//                      sell units in the rescaled trade and rescales offers
+func (t *SimpleChaincode ) testSplitCashInLieu(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    propertyID, err := t.testIssueProperty(stub, "1 Split St", "testsplitissuer", "", 10, []Holding{{Entity: "testsplitb", Units: 3}, {Entity: "testsplitc", Units: 5}})
+    _, err2 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"testsplitc","direction":"S","propertyID":"` + propertyID + `","price":10,"units":2}`})
+    bytes, err3 := t.dispatch(stub, FUNCTION_INVOKE, "generateOffer", []string{"testsplitissuer", propertyID, "4"})
+    var offer Offer
+    json.Unmarshal(bytes, &offer)
+    _, err4 := t.dispatch(stub, FUNCTION_INVOKE, "splitUnits", []string{"testsplitissuer", propertyID, "1", "2"})
+
+    issuer, _ := getAccount(stub, "testsplitissuer")
+    holder, _ := getAccount(stub, "testsplitb")
+    seller, _ := getAccount(stub, "testsplitc")
+    propertyAccount, _ := getAccount(stub, propertyID)
+    resting, _ := getPropertyTrades(stub, propertyID)
+    offer, err5 := getOffer(stub, offer.ID)
+    if !checkErrors(err) && !checkErrors(err2) && !checkErrors(err3) && !checkErrors(err4) && !checkErrors(err5) &&
+        holder.getHolding(propertyID) == 1 && holder.Cash == 1010 && seller.getHolding(propertyID) == 1 && seller.Cash == 1010 &&
+        len(resting) == 1 && resting[0].Units == 1 && propertyAccount.getHolding("testsplitc") == 2 &&
+        issuer.getHolding(propertyID) == 1 && issuer.Cash == 980 && offer.Units == 2 && offer.Price == 20 {
+        responses = append(responses, "COMPLETE: Splitting pays cash in lieu of fractions and rescales escrowed trades and offers")
+    } else {
+        responses = append(responses, "FAIL: splitting should pay cash in lieu of fractions and rescale escrowed trades and offers")
+    }
+    return responses
+}
+
+//testRightsEscrow - rights offers hold the issuer's units until they are taken up or withdrawn
+func (t *SimpleChaincode ) testRightsEscrow(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    propertyID, err := t.testIssueProperty(stub, "1 Rights St", "testrightsissuer", "", 10, []Holding{{Entity: "testrightsb", Units: 5}})
+    bytes, err2 := t.dispatch(stub, FUNCTION_INVOKE, "issueUnits", []string{"testrightsissuer", propertyID, "10", "2", "100"})
+    var result IssueResult
+    json.Unmarshal(bytes, &result)
+    escrowed, _ := getAccount(stub, "testrightsissuer")
+    var err3, err4 error
+    if len(result.Rights) == 1 {
+        _, err3 = t.dispatch(stub, FUNCTION_INVOKE, "acceptOffer", []string{result.Rights[0].ID, "testrightsb", "2"})
+        err4 = cancelPropertyOrders(stub, propertyID)
+    }
+    issuer, _ := getAccount(stub, "testrightsissuer")
+    holder, _ := getAccount(stub, "testrightsb")
+    if !checkErrors(err) && !checkErrors(err2) && len(result.Rights) == 1 && result.Rights[0].Units == 5 && escrowed.getHolding(propertyID) == 10 &&
+        !checkErrors(err3) && !checkErrors(err4) && holder.getHolding(propertyID) == 7 && holder.Cash == 996 && issuer.getHolding(propertyID) == 13 {
+        responses = append(responses, "COMPLETE: Rights offers escrow the issuer's units until they are taken up or withdrawn")
+    } else {
+        responses = append(responses, "FAIL: rights offers should escrow the issuer's units until they are taken up or withdrawn")
+    }
+    return responses
+}
+
+//testDistribution - rent paid into the operating account is distributed pro rata, rounded down, with the remainder
+//                   left in the account
+func (t *SimpleChaincode ) testDistribution(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    propertyID, err := t.testIssueProperty(stub, "1 Distribution St", "testdistissuer", "", 3, []Holding{{Entity: "testdistb", Units: 1}})
+    _, err2 := t.dispatch(stub, FUNCTION_INVOKE, "receiveRent", []string{"testdistissuer", propertyID, "10"})
+    _, err3 := t.dispatch(stub, FUNCTION_INVOKE, "distributeIncome", []string{"testdistissuer", propertyID})
+    issuer, _ := getAccount(stub, "testdistissuer")
+    holder, _ := getAccount(stub, "testdistb")
+    propertyAccount, _ := getAccount(stub, propertyID)
+    if !checkErrors(err) && !checkErrors(err2) && !checkErrors(err3) && issuer.Cash == 996.66 && holder.Cash == 1003.33 &&
+        propertyAccount.Cash == 0.01 && propertyAccount.Role == ROLE_PROPERTY {
+        responses = append(responses, "COMPLETE: Operating cash is distributed pro rata and the remainder stays in the account")
+    } else {
+        responses = append(responses, "FAIL: operating cash should be distributed pro rata and the remainder should stay in the account")
+    }
+    return responses
+}
+
+//testSnapshotDistribution - a distribution against a snapshot pays the holders of record, not whoever holds the units now
+func (t *SimpleChaincode ) testSnapshotDistribution(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    propertyID, err := t.testIssueProperty(stub, "1 Snapshot St", "testsnapissuer", "", 4, []Holding{{Entity: "testsnapb", Units: 2}})
+    bytes, err2 := t.dispatch(stub, FUNCTION_INVOKE, "takeSnapshot", []string{"testsnapissuer", propertyID, "record"})
+    var snapshot Snapshot
+    json.Unmarshal(bytes, &snapshot)
+    _, err3 := t.dispatch(stub, FUNCTION_INVOKE, "takeSnapshot", []string{"testsnapissuer", propertyID, "record"})
+
+    buyer := Account{ID: "testsnapc", Role: ROLE_PRIVATE_ENTITY, Cash: 1000}
+    buyer.create(stub)
+    _, err4 := t.dispatch(stub, FUNCTION_INVOKE, "transfer", []string{"testexchange", "testsnapb", buyer.ID, propertyID, "2"})
+    _, err5 := t.dispatch(stub, FUNCTION_INVOKE, "receiveRent", []string{"testsnapissuer", propertyID, "8"})
+    _, err6 := t.dispatch(stub, FUNCTION_INVOKE, "distributeIncome", []string{"testsnapissuer", propertyID, "", snapshot.ID})
+
+    issuer, _ := getAccount(stub, "testsnapissuer")
+    holder, _ := getAccount(stub, "testsnapb")
+    buyer, _ = getAccount(stub, buyer.ID)
+    if !checkErrors(err) && !checkErrors(err2) && errorCode(err3) == ERR_CONFLICT && !checkErrors(err4) && !checkErrors(err5) && !checkErrors(err6) &&
+        len(snapshot.Holdings) == 2 && snapshot.Units == 4 && issuer.Cash == 996 && holder.Cash == 1004 && holder.getHolding(propertyID) == 0 &&
+        buyer.Cash == 1000 && buyer.getHolding(propertyID) == 2 {
+        responses = append(responses, "COMPLETE: Distributions against a snapshot pay the holders of record")
+    } else {
+        responses = append(responses, "FAIL: distributions against a snapshot should pay the holders of record")
+    }
+    return responses
+}
+
+//testUnderwritingExercise - closing an offer has the underwriter take up the unsold units, or releases the commitment
+//                           when the seller can no longer deliver them
+func (t *SimpleChaincode ) testUnderwritingExercise(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    propertyID, err := t.testIssueProperty(stub, "1 Underwriting St", "testuwissuer", "", 10, nil)
+    underwriter := Account{ID: "testuwmm", Role: ROLE_MARKET_MAKER, Cash: 1000}
+    underwriter.create(stub)
+    now, _ := getTxTime(stub)
+
+    //the offers lapse straight away as the test can't wait for them to close
+    bytes, err2 := t.dispatch(stub, FUNCTION_INVOKE, "generateOffer", []string{"testuwissuer", propertyID, "4", "50", "8"})
+    var offer Offer
+    json.Unmarshal(bytes, &offer)
+    _, err3 := t.dispatch(stub, FUNCTION_INVOKE, "underwriteOffer", []string{underwriter.ID, offer.ID, "4", "8"})
+    offer, _ = getOffer(stub, offer.ID)
+    offer.Expiry = now
+    offer.save(stub)
+    bytes, err4 := t.dispatch(stub, FUNCTION_INVOKE, "closeOffer", []string{offer.ID})
+    var uw Underwriting
+    json.Unmarshal(bytes, &uw)
+
+    //the issuer has 6 units left so can't deliver 8
+    before, _ := getAccount(stub, underwriter.ID)
+    bytes, err5 := t.dispatch(stub, FUNCTION_INVOKE, "generateOffer", []string{"testuwissuer", propertyID, "8", "50", "8"})
+    json.Unmarshal(bytes, &offer)
+    _, err6 := t.dispatch(stub, FUNCTION_INVOKE, "underwriteOffer", []string{underwriter.ID, offer.ID, "8", "8"})
+    offer, _ = getOffer(stub, offer.ID)
+    offer.Expiry = now
+    offer.save(stub)
+    bytes, err7 := t.dispatch(stub, FUNCTION_INVOKE, "closeOffer", []string{offer.ID})
+    var released Underwriting
+    json.Unmarshal(bytes, &released)
+    _, err8 := getOffer(stub, offer.ID)
+
+    issuer, _ := getAccount(stub, "testuwissuer")
+    underwriter, _ = getAccount(stub, underwriter.ID)
+    if !checkErrors(err) && !checkErrors(err2) && !checkErrors(err3) && !checkErrors(err4) && !checkErrors(err5) && !checkErrors(err6) && !checkErrors(err7) &&
+        uw.Status == UNDERWRITING_EXERCISED && uw.Taken == 4 && underwriter.getHolding(propertyID) == 4 && issuer.getHolding(propertyID) == 6 &&
+        released.Status == UNDERWRITING_RELEASED && underwriter.Cash == before.Cash && isNotFound(err8) {
+        responses = append(responses, "COMPLETE: Closing an offer exercises the underwriting or releases it when the units can't be delivered")
+    } else {
+        responses = append(responses, "FAIL: closing an offer should exercise the underwriting or release it when the units can't be delivered")
+    }
+    return responses
+}
+
+//testTradeIDs - two trades on the same side by one account in one transaction get their own IDs
+func (t *SimpleChaincode ) testTradeIDs(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    propertyID, err := t.testIssueProperty(stub, "1 Trade ID St", "testidissuer", "", 10, nil)
+    order := `{"accountID":"testidissuer","direction":"S","propertyID":"` + propertyID + `","price":20,"units":1}`
+    _, err2 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{order})
+    _, err3 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{order})
+    resting, _ := getPropertyTrades(stub, propertyID)
+    if !checkErrors(err) && !checkErrors(err2) && !checkErrors(err3) && len(resting) == 2 && resting[0].ID != resting[1].ID {
+        responses = append(responses, "COMPLETE: Trades created together get their own IDs")
+    } else {
+        responses = append(responses, "FAIL: trades created together should get their own IDs")
+    }
+    return responses
+}
+
+//testTradeValueLimit - market and stop trades are valued at the reference price, and a market buy at the prices it
+//                      would fill at too, so changing the order type doesn't get around the maximum trade value
+func (t *SimpleChaincode ) testTradeValueLimit(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    limits := config.Limits
+    config.Limits.MaxTradeValue = 105
+    propertyID, err := t.testIssueProperty(stub, "1 Value St", "testvalueissuer", "", 20, []Holding{{Entity: "testvalueb", Units: 1}})
+    _, err2 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"testvalueissuer","direction":"S","propertyID":"` + propertyID + `","price":12,"units":5}`})
+    _, err3 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"testvalueissuer","direction":"S","propertyID":"` + propertyID + `","price":12,"units":4}`})
+    _, err4 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"testvalueb","direction":"B","propertyID":"` + propertyID + `","orderType":"MARKET","units":11}`})
+    _, err5 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"testvalueb","direction":"B","propertyID":"` + propertyID + `","orderType":"STOP","stopPrice":20,"units":6}`})
+    _, err6 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"testvalueb","direction":"B","propertyID":"` + propertyID + `","orderType":"MARKET","units":9}`})
+    _, err7 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"testvalueb","direction":"B","propertyID":"` + propertyID + `","orderType":"MARKET","units":5}`})
+    config.Limits = limits
+
+    holder, _ := getAccount(stub, "testvalueb")
+    if !checkErrors(err) && !checkErrors(err2) && !checkErrors(err3) && errorCode(err4) == ERR_INVALID_ARGUMENT && errorCode(err5) == ERR_INVALID_ARGUMENT &&
+        errorCode(err6) == ERR_INVALID_ARGUMENT && !checkErrors(err7) && holder.getHolding(propertyID) == 6 {
+        responses = append(responses, "COMPLETE: Market and stop trades are held to the maximum trade value")
+    } else {
+        responses = append(responses, "FAIL: market and stop trades should be held to the maximum trade value")
+    }
+    return responses
+}
+
+//testSelfTradePrevention - reaching the buyer's own sell cancels the new buy, the old sell or both. When only the old
+//                          sell is cancelled a market buy escrows the fills past it, so a stop that can't pay for them
+//                          is cancelled rather than failing the trade that triggered it
+func (t *SimpleChaincode ) testSelfTradePrevention(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    //the units the buyer ends up holding and how many trades are left resting for each mode
+    expected := map[string][]int{STP_CANCEL_NEWEST: {0, 2}, STP_CANCEL_OLDEST: {10, 1}, STP_CANCEL_BOTH: {5, 1}}
+    for _, mode := range []string{STP_CANCEL_NEWEST, STP_CANCEL_OLDEST, STP_CANCEL_BOTH} {
+        buyerID, sellerID := "teststpbuyer" + mode, "teststpseller" + mode
+        propertyID, err := t.testIssueProperty(stub, "1 " + mode + " St", "teststpissuer", "", 20, []Holding{{Entity: buyerID, Units: 5}, {Entity: sellerID, Units: 5}})
+        _, err2 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"` + buyerID + `","direction":"S","propertyID":"` + propertyID + `","price":10,"units":5}`})
+        _, err3 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"` + sellerID + `","direction":"S","propertyID":"` + propertyID + `","price":11,"units":5}`})
+        _, err4 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"` + buyerID + `","direction":"B","propertyID":"` + propertyID + `","price":12,"units":10,"selfTradePrevention":"` + mode + `"}`})
+
+        buyer, _ := getAccount(stub, buyerID)
+        resting, _ := getPropertyTrades(stub, propertyID)
+        if !checkErrors(err) && !checkErrors(err2) && !checkErrors(err3) && !checkErrors(err4) &&
+            buyer.getHolding(propertyID) == expected[mode][0] && len(resting) == expected[mode][1] {
+            responses = append(responses, "COMPLETE: Self trade prevention " + mode + " cancels the right trades")
+        } else {
+            responses = append(responses, "FAIL: self trade prevention " + mode + " should cancel the right trades")
+        }
+    }
+
+    propertyID, err := t.testIssueProperty(stub, "1 Market Self Trade St", "teststpissuer", "", 20, []Holding{{Entity: "teststpmarketb", Units: 5}, {Entity: "teststpmarketc", Units: 10}})
+    _, err2 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"teststpmarketb","direction":"S","propertyID":"` + propertyID + `","price":10,"units":5}`})
+    _, err3 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"teststpmarketc","direction":"S","propertyID":"` + propertyID + `","price":11,"units":5}`})
+    _, err4 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"teststpmarketc","direction":"S","propertyID":"` + propertyID + `","price":12,"units":5}`})
+    _, err5 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"teststpmarketb","direction":"B","propertyID":"` + propertyID + `","orderType":"MARKET","units":10,"selfTradePrevention":"CANCEL_OLDEST"}`})
+    buyer, _ := getAccount(stub, "teststpmarketb")
+    resting, _ := getPropertyTrades(stub, propertyID)
+    if !checkErrors(err) && !checkErrors(err2) && !checkErrors(err3) && !checkErrors(err4) && !checkErrors(err5) &&
+        buyer.getHolding(propertyID) == 15 && buyer.Cash == 885 && len(resting) == 0 {
+        responses = append(responses, "COMPLETE: A market buy fills past its own cancelled trade")
+    } else {
+        responses = append(responses, "FAIL: a market buy should fill past its own cancelled trade")
+    }
+
+    //a stop that can't pay for the fills past its own trade is cancelled without failing the trade that triggered it
+    propertyID, err = t.testIssueProperty(stub, "1 Stop Self Trade St", "teststpissuer", "", 20, []Holding{{Entity: "teststpstopb", Units: 5}, {Entity: "teststpstopc", Units: 10}})
+    other := Account{ID: "teststpstopd", Role: ROLE_PRIVATE_ENTITY, Cash: 1000}
+    other.create(stub)
+    _, err2 = t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"teststpstopb","direction":"S","propertyID":"` + propertyID + `","price":10,"units":5}`})
+    _, err3 = t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"teststpstopc","direction":"S","propertyID":"` + propertyID + `","price":11,"units":5}`})
+    _, err4 = t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"teststpstopc","direction":"S","propertyID":"` + propertyID + `","price":12,"units":5}`})
+    _, err5 = t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"teststpstopb","direction":"B","propertyID":"` + propertyID + `","orderType":"STOP","stopPrice":10,"units":10,"selfTradePrevention":"CANCEL_OLDEST"}`})
+    buyer, _ = getAccount(stub, "teststpstopb")
+    buyer.Cash = 100
+    buyer.save(stub)
+    _, err6 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"teststpstopd","direction":"B","propertyID":"` + propertyID + `","price":10,"units":1}`})
+    other, _ = getAccount(stub, other.ID)
+    resting, _ = getPropertyTrades(stub, propertyID)
+    if !checkErrors(err) && !checkErrors(err2) && !checkErrors(err3) && !checkErrors(err4) && !checkErrors(err5) && !checkErrors(err6) &&
+        other.getHolding(propertyID) == 1 && len(resting) == 3 {
+        responses = append(responses, "COMPLETE: A stop that can't pay for the fills past its own trade is cancelled")
+    } else {
+        responses = append(responses, "FAIL: a stop that can't pay for the fills past its own trade should be cancelled")
+    }
+    return responses
+}
+
+//testAccountLimits - a role's limits cap buying into a property through a trade or an offer, the notional of open
+//                    trades and the day's withdrawals, and an account's own limits replace them
+func (t *SimpleChaincode ) testAccountLimits(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    roleLimits := config.RoleLimits
+    config.RoleLimits = map[string]AccountLimits{strconv.Itoa(ROLE_PRIVATE_ENTITY): {MaxHoldingPct: 30, MaxOpenNotional: 100, MaxDailyWithdrawal: 50}}
+    propertyID, err := t.testIssueProperty(stub, "1 Limits St", "testlimitissuer", "", 10, []Holding{{Entity: "testlimitb", Units: 2}})
+    bytes, err2 := t.dispatch(stub, FUNCTION_INVOKE, "generateOffer", []string{"testlimitissuer", propertyID, "2"})
+    var offer Offer
+    json.Unmarshal(bytes, &offer)
+
+    order := `{"accountID":"testlimitb","direction":"B","propertyID":"` + propertyID + `","price":10,"units":2}`
+    _, err3 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{order})
+    order = `{"accountID":"testlimitb","direction":"B","propertyID":"` + propertyID + `","price":10,"units":1}`
+    _, err4 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{order})
+    _, err5 := t.dispatch(stub, FUNCTION_INVOKE, "acceptOffer", []string{offer.ID, "testlimitb", "1"})
+    order = `{"accountID":"testlimitb","direction":"S","propertyID":"` + propertyID + `","price":50,"units":2}`
+    _, err6 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{order})
+    _, err7 := t.dispatch(stub, FUNCTION_INVOKE, "requestWithdrawal", []string{"testlimitb", "40", "TESTLIMIT1"})
+    _, err8 := t.dispatch(stub, FUNCTION_INVOKE, "requestWithdrawal", []string{"testlimitb", "20", "TESTLIMIT2"})
+
+    //the account's own limits replace every limit of its role, so withdrawals are no longer capped
+    admin := Account{ID: "testlimitadmin", Role: ROLE_ADMIN}
+    admin.create(stub)
+    _, err9 := t.dispatch(stub, FUNCTION_INVOKE, "setAccountLimits", []string{admin.ID, "testlimitb", `{"maxHoldingPct":50}`})
+    _, err10 := t.dispatch(stub, FUNCTION_INVOKE, "acceptOffer", []string{offer.ID, "testlimitb", "1"})
+    _, err11 := t.dispatch(stub, FUNCTION_INVOKE, "requestWithdrawal", []string{"testlimitb", "20", "TESTLIMIT3"})
+    config.RoleLimits = roleLimits
+
+    holder, _ := getAccount(stub, "testlimitb")
+    if !checkErrors(err) && !checkErrors(err2) && errorCode(err3) == ERR_STATE_VIOLATION && !checkErrors(err4) && errorCode(err5) == ERR_STATE_VIOLATION &&
+        errorCode(err6) == ERR_STATE_VIOLATION && !checkErrors(err7) && errorCode(err8) == ERR_STATE_VIOLATION && !checkErrors(err9) &&
+        !checkErrors(err10) && !checkErrors(err11) && holder.getHolding(propertyID) == 3 {
+        responses = append(responses, "COMPLETE: Role limits cap holdings, open notional and withdrawals unless the account has its own")
+    } else {
+        responses = append(responses, "FAIL: role limits should cap holdings, open notional and withdrawals unless the account has its own")
+    }
+    return responses
+}
+
+//testTradingSessions - trades are refused on a halted property, on a holiday and while the market is closed. Before the
+//                      open only resting limit trades are taken, and opening uncrosses them
+func (t *SimpleChaincode ) testTradingSessions(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    propertyID, err := t.testIssueProperty(stub, "1 Session St", "testsessionissuer", "", 10, []Holding{{Entity: "testsessionb", Units: 5}, {Entity: "testsessionc", Units: 1}})
+    sell := `{"accountID":"testsessionb","direction":"S","propertyID":"` + propertyID + `","price":10,"units":5}`
+    now, _ := getTxTime(stub)
+    today := time.Unix(now, 0).UTC().Format("2006-01-02")
+
+    _, err2 := t.dispatch(stub, FUNCTION_INVOKE, "haltProperty", []string{"testexchange", propertyID})
+    _, err3 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{sell})
+    t.dispatch(stub, FUNCTION_INVOKE, "resumeProperty", []string{"testexchange", propertyID})
+    _, err4 := t.dispatch(stub, FUNCTION_INVOKE, "setCalendar", []string{"testexchange", `{"holidays":["` + today + `"]}`})
+    _, err5 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{sell})
+    t.dispatch(stub, FUNCTION_INVOKE, "setCalendar", []string{"testexchange", `{}`})
+    _, err6 := t.dispatch(stub, FUNCTION_INVOKE, "setSession", []string{"testexchange", SESSION_CLOSED})
+    _, err7 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{sell})
+
+    _, err8 := t.dispatch(stub, FUNCTION_INVOKE, "setSession", []string{"testexchange", SESSION_PRE_OPEN})
+    _, err9 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{sell})
+    _, err10 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"testsessionc","direction":"B","propertyID":"` + propertyID + `","orderType":"MARKET","units":1}`})
+    _, err11 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"testsessionc","direction":"B","propertyID":"` + propertyID + `","price":12,"units":4,"timeInForce":"IOC"}`})
+    _, err12 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"testsessionc","direction":"B","propertyID":"` + propertyID + `","price":12,"units":4}`})
+    resting, _ := getPropertyTrades(stub, propertyID)
+    bytes, err13 := t.dispatch(stub, FUNCTION_INVOKE, "setSession", []string{"testexchange", SESSION_CONTINUOUS})
+    stub.DelState(MARKET_KEY)
+
+    var executions []Execution
+    json.Unmarshal(bytes, &executions)
+    var uncrossed int
+    for i := 0; i < len(executions); i++ {
+        if executions[i].PropertyID == propertyID {uncrossed += executions[i].Units}
+    }
+    buyer, _ := getAccount(stub, "testsessionc")
+    if !checkErrors(err) && !checkErrors(err2) && errorCode(err3) == ERR_STATE_VIOLATION && !checkErrors(err4) && errorCode(err5) == ERR_STATE_VIOLATION &&
+        !checkErrors(err6) && errorCode(err7) == ERR_STATE_VIOLATION && !checkErrors(err8) && !checkErrors(err9) && errorCode(err10) == ERR_INVALID_ARGUMENT &&
+        errorCode(err11) == ERR_INVALID_ARGUMENT && !checkErrors(err12) && len(resting) == 2 && !checkErrors(err13) && uncrossed == 4 && buyer.getHolding(propertyID) == 5 {
+        responses = append(responses, "COMPLETE: Sessions refuse trades until the open, which uncrosses the book")
+    } else {
+        responses = append(responses, "FAIL: sessions should refuse trades until the open, which should uncross the book")
+    }
+    return responses
+}
+
+//testIssuedTradingState - an issuer can't set a property's last price, halt, status or version when issuing it
+func (t *SimpleChaincode ) testIssuedTradingState(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    issuer := Account{ID: "testissuedissuer", Role: ROLE_PRIVATE_ENTITY, Cash: 1000}
+    issuer.create(stub)
+    property := Property{AddressLine: "1 Issued St", Suburb: "Test", State: "NSW", PostCode: "2000", Issuer: issuer.ID, Units: 10, Valuation: 100,
+        LastPrice: 500, Halted: true, HaltedUntil: 1 << 40, Status: PROPERTY_STATE_SOLD, Version: 7}
+    bytes, _ := json.Marshal(property)
+    _, err := t.dispatch(stub, FUNCTION_INVOKE, "issueProperty", []string{string(bytes)})
+    property, err2 := getProperty(stub, getMd5Hash(property.AddressLine + property.Suburb + property.State + property.PostCode))
+    if !checkErrors(err) && !checkErrors(err2) && property.LastPrice == 0 && property.referencePrice() == 10 && !property.Halted &&
+        property.HaltedUntil == 0 && property.Status == PROPERTY_STATE_PROPOSED && property.Version == 1 {
+        responses = append(responses, "COMPLETE: Issuing a property starts its trading state afresh")
+    } else {
+        responses = append(responses, "FAIL: issuing a property should start its trading state afresh")
+    }
+    return responses
+}
+
+//testPriceRules - trades must be on the property's tick and at least its minimum lot, and one priced outside the band
+//                 is cancelled and halts the property
+func (t *SimpleChaincode ) testPriceRules(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    band := config.PriceBand
+    config.PriceBand = PriceBand{Pct: 10, HaltSeconds: 60}
+    propertyID, err := t.testIssueProperty(stub, "1 Price Rules St", "testrulesissuer", "", 100, nil)
+    _, err2 := t.dispatch(stub, FUNCTION_INVOKE, "setTradingRules", []string{"testexchange", propertyID, `{"tickSize":0.5,"minLot":10}`})
+    _, err3 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"testrulesissuer","direction":"S","propertyID":"` + propertyID + `","price":10.25,"units":10}`})
+    _, err4 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"testrulesissuer","direction":"S","propertyID":"` + propertyID + `","price":10.5,"units":5}`})
+    bytes, err5 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"testrulesissuer","direction":"S","propertyID":"` + propertyID + `","price":12,"units":10}`})
+    config.PriceBand = band
+
+    var result TradeResult
+    json.Unmarshal(bytes, &result)
+    now, _ := getTxTime(stub)
+    issuer, _ := getAccount(stub, "testrulesissuer")
+    property, _ := getProperty(stub, propertyID)
+    resting, _ := getPropertyTrades(stub, propertyID)
+    if !checkErrors(err) && !checkErrors(err2) && errorCode(err3) == ERR_INVALID_ARGUMENT && errorCode(err4) == ERR_INVALID_ARGUMENT && !checkErrors(err5) &&
+        result.Trade.Status == TRADE_STATE_CANCELLED && property.HaltedUntil == now + 60 && len(resting) == 0 && issuer.getHolding(propertyID) == 100 {
+        responses = append(responses, "COMPLETE: Trades off the tick or under the minimum lot are rejected and one outside the band halts the property")
+    } else {
+        responses = append(responses, "FAIL: trades off the tick or under the minimum lot should be rejected and one outside the band should halt the property")
+    }
+    return responses
+}
+
+//testManagerReplacement - the manager's fee accrues on rent and is paid before a distribution. A nomination that fails
+//                         its vote is dropped, one that wins pays the old manager what they have accrued and appoints
+//                         the nominee
+func (t *SimpleChaincode ) testManagerReplacement(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    propertyID, err := t.testIssueProperty(stub, "1 Management St", "testmgmtissuer", "", 100, []Holding{{Entity: "testmgmtb", Units: 30}, {Entity: "testmgmtc", Units: 30}})
+    otherID, err2 := t.testIssueProperty(stub, "2 Management St", "testmgmtissuer", "", 100, []Holding{{Entity: "testmgmtb", Units: 30}})
+    for _, id := range []string{"testmgmtm1", "testmgmtm2", "testmgmtm3"} {
+        manager := Account{ID: id, Role: ROLE_MANAGER, Cash: 1000}
+        manager.create(stub)
+    }
+
+    //the test can't wait for a vote to close so it closes it now. Rent and proposals are one per property in a
+    //transaction, so each property has one of each
+    now, _ := getTxTime(stub)
+    closesAt := strconv.FormatInt(now + 100, 10)
+    closeVote := func(bytes []byte) error {
+        var property Property
+        json.Unmarshal(bytes, &property)
+        if property.Nomination == nil {return newError(ERR_STATE_VIOLATION, "Nothing was nominated")}
+        proposal, err := getProposal(stub, property.Nomination.ProposalID)
+        if checkErrors(err){return err}
+        proposal.ClosesAt = now
+        err = proposals.save(stub, &proposal)
+        if checkErrors(err){return err}
+        _, err = t.dispatch(stub, FUNCTION_INVOKE, "closeProposal", []string{proposal.ID})
+        return err
+    }
+
+    //the fee is paid before the rest of the rent is distributed and a nomination with too few votes is dropped
+    _, err3 := t.dispatch(stub, FUNCTION_INVOKE, "assignManager", []string{"testmgmtissuer", otherID, "testmgmtm2", "500", "0"})
+    _, err4 := t.dispatch(stub, FUNCTION_INVOKE, "receiveRent", []string{"testmgmtm2", otherID, "100"})
+    accrued, _ := getProperty(stub, otherID)
+    _, err5 := t.dispatch(stub, FUNCTION_INVOKE, "distributeIncome", []string{"testmgmtm2", otherID})
+    bytes, err6 := t.dispatch(stub, FUNCTION_INVOKE, "nominateManager", []string{"testmgmtb", otherID, "testmgmtm3", "300", "0", closesAt})
+    err7 := closeVote(bytes)
+    rejected, _ := getProperty(stub, otherID)
+    distributing, _ := getAccount(stub, "testmgmtm2")
+    holder, _ := getAccount(stub, "testmgmtb")
+
+    //a nomination with a majority pays the old manager's accrued fee and appoints the nominee
+    _, err8 := t.dispatch(stub, FUNCTION_INVOKE, "assignManager", []string{"testmgmtissuer", propertyID, "testmgmtm1", "500", "0"})
+    _, err9 := t.dispatch(stub, FUNCTION_INVOKE, "receiveRent", []string{"testmgmtm1", propertyID, "20"})
+    bytes, err10 := t.dispatch(stub, FUNCTION_INVOKE, "nominateManager", []string{"testmgmtb", propertyID, "testmgmtm3", "300", "0", closesAt})
+    var nominated Property
+    json.Unmarshal(bytes, &nominated)
+    var err11 error
+    if nominated.Nomination != nil {
+        _, err11 = t.dispatch(stub, FUNCTION_INVOKE, "castVote", []string{nominated.Nomination.ProposalID, "testmgmtc", NOMINATION_FOR})
+    }
+    err12 := closeVote(bytes)
+    property, _ := getProperty(stub, propertyID)
+    replaced, _ := getAccount(stub, "testmgmtm1")
+
+    if !checkErrors(err) && !checkErrors(err2) && !checkErrors(err3) && !checkErrors(err4) && !checkErrors(err5) && !checkErrors(err6) &&
+        !checkErrors(err7) && !checkErrors(err8) && !checkErrors(err9) && !checkErrors(err10) && !checkErrors(err11) && !checkErrors(err12) &&
+        accrued.Management.Accrued == 5 && distributing.Cash == 905 && holder.Cash == 1028.5 && rejected.ManagedBy == "testmgmtm2" &&
+        rejected.Nomination == nil && property.ManagedBy == "testmgmtm3" && property.Management.FeeBps == 300 && property.Nomination == nil &&
+        replaced.Cash == 981 {
+        responses = append(responses, "COMPLETE: Management fees are paid before distributions and replacing the manager")
+    } else {
+        responses = append(responses, "FAIL: management fees should be paid before distributions and replacing the manager")
+    }
+    return responses
+}
+
+//testProposalVoting - holders vote once each before the proposal closes, with units escrowed in an open sell counting
+//                     towards quorum and the outcome, and the votes can only be counted once it has closed
+func (t *SimpleChaincode ) testProposalVoting(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    propertyID, err := t.testIssueProperty(stub, "1 Proposal St", "testvoteissuer", "", 100, []Holding{{Entity: "testvoteb", Units: 30}, {Entity: "testvotec", Units: 10}})
+    outsider := Account{ID: "testvoted", Role: ROLE_PRIVATE_ENTITY, Cash: 1000}
+    outsider.create(stub)
+    _, err2 := t.dispatch(stub, FUNCTION_INVOKE, "createTrade", []string{`{"accountID":"testvoteb","direction":"S","propertyID":"` + propertyID + `","price":10,"units":20}`})
+
+    //without the escrowed units testvoteb's vote would tie with testvotec's and miss quorum
+    now, _ := getTxTime(stub)
+    bytes, err3 := t.dispatch(stub, FUNCTION_INVOKE, "createProposal", []string{"testvoteb", propertyID, "Paint it", `["yes","no"]`, strconv.FormatInt(now + 100, 10), "35"})
+    var proposal Proposal
+    json.Unmarshal(bytes, &proposal)
+    _, err4 := t.dispatch(stub, FUNCTION_INVOKE, "castVote", []string{proposal.ID, "testvoteb", "yes"})
+    _, err5 := t.dispatch(stub, FUNCTION_INVOKE, "castVote", []string{proposal.ID, "testvoteb", "no"})
+    _, err6 := t.dispatch(stub, FUNCTION_INVOKE, "castVote", []string{proposal.ID, outsider.ID, "no"})
+    _, err7 := t.dispatch(stub, FUNCTION_INVOKE, "castVote", []string{proposal.ID, "testvotec", "no"})
+    _, err8 := t.dispatch(stub, FUNCTION_INVOKE, "closeProposal", []string{proposal.ID})
+
+    //the test can't wait for the proposal to close so it closes it now
+    proposal, _ = getProposal(stub, proposal.ID)
+    proposal.ClosesAt = now
+    proposals.save(stub, &proposal)
+    _, err9 := t.dispatch(stub, FUNCTION_INVOKE, "castVote", []string{proposal.ID, "testvoteissuer", "no"})
+    bytes, err10 := t.dispatch(stub, FUNCTION_INVOKE, "closeProposal", []string{proposal.ID})
+    json.Unmarshal(bytes, &proposal)
+
+    if !checkErrors(err) && !checkErrors(err2) && !checkErrors(err3) && !checkErrors(err4) && errorCode(err5) == ERR_CONFLICT &&
+        errorCode(err6) == ERR_UNAUTHORISED && !checkErrors(err7) && errorCode(err8) == ERR_STATE_VIOLATION && errorCode(err9) == ERR_STATE_VIOLATION &&
+        !checkErrors(err10) && proposal.Status == PROPOSAL_CLOSED && proposal.Voted == 40 && proposal.QuorumMet && proposal.Outcome == "yes" {
+        responses = append(responses, "COMPLETE: Holders vote once before the close, escrowed units count and the votes are counted after")
+    } else {
+        responses = append(responses, "FAIL: holders should vote once before the close, escrowed units should count and the votes should be counted after")
+    }
+    return responses
+}
+
+//testForcedUnderwriting - a third party can neither offer the issuer's units nor underwrite an offer below the
+//                         seller's minimum floor price, so the issuer can't be forced to sell cheaply
+func (t *SimpleChaincode ) testForcedUnderwriting(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    propertyID, err := t.testIssueProperty(stub, "1 Forced St", "testforcedissuer", "", 10, nil)
+    underwriter := Account{ID: "testforcedmm", Role: ROLE_MARKET_MAKER, Cash: 1000}
+    underwriter.create(stub)
+
+    _, err2 := t.dispatch(stub, FUNCTION_INVOKE, "generateOffer", []string{underwriter.ID, propertyID, "10", "50", "1"})
+
+    //without a minimum floor the offer can't be underwritten at all
+    bytes, err3 := t.dispatch(stub, FUNCTION_INVOKE, "generateOffer", []string{"testforcedissuer", propertyID, "3", "50"})
+    var open Offer
+    json.Unmarshal(bytes, &open)
+    _, err4 := t.dispatch(stub, FUNCTION_INVOKE, "underwriteOffer", []string{underwriter.ID, open.ID, "3", "1"})
+
+    bytes, err5 := t.dispatch(stub, FUNCTION_INVOKE, "generateOffer", []string{"testforcedissuer", propertyID, "4", "50", "8"})
+    var floored Offer
+    json.Unmarshal(bytes, &floored)
+    _, err6 := t.dispatch(stub, FUNCTION_INVOKE, "underwriteOffer", []string{underwriter.ID, floored.ID, "4", "5"})
+
+    issuer, _ := getAccount(stub, "testforcedissuer")
+    underwriter, _ = getAccount(stub, underwriter.ID)
+    if !checkErrors(err) && errorCode(err2) == ERR_UNAUTHORISED && !checkErrors(err3) && errorCode(err4) == ERR_UNAUTHORISED &&
+        !checkErrors(err5) && errorCode(err6) == ERR_INVALID_ARGUMENT && floored.MinFloorPrice == 8 &&
+        issuer.getHolding(propertyID) == 10 && underwriter.getHolding(propertyID) == 0 && underwriter.Cash == 1000 {
+        responses = append(responses, "COMPLETE: A third party can't offer the issuer's units or underwrite below the seller's minimum floor")
+    } else {
+        responses = append(responses, "FAIL: a third party shouldn't be able to offer the issuer's units or underwrite below the seller's minimum floor")
+    }
+    return responses
+}
+
+//testIssueProperty - issue a property to the issuer, who has 1000 cash, and have the exchange transfer units to each
+//                    holder, who also has 1000 cash. Missing accounts are created
+func (t *SimpleChaincode ) testIssueProperty(stub *shim.ChaincodeStub, addressLine string, issuerID string, managerID string, units int, holders []Holding) (string, error) {
+    exchange := Account{ID: "testexchange", Role: ROLE_EXCHANGE}
+    exchange.create(stub)
+    for _, id := range []string{issuerID, managerID} {
+        account := Account{ID: id, Role: ROLE_PRIVATE_ENTITY, Cash: 1000}
+        if id == managerID {account.Role = ROLE_MANAGER}
+        if id != "" {account.create(stub)}
+    }
+
+    property := Property{AddressLine: addressLine, Suburb: "Test", State: "NSW", PostCode: "2000", Issuer: issuerID, ManagedBy: managerID, Units: units, Valuation: float64(units) * 10}
+    bytes, _ := json.Marshal(property)
+    _, err := t.dispatch(stub, FUNCTION_INVOKE, "issueProperty", []string{string(bytes)})
+    if checkErrors(err){return "", err}
+    propertyID := getMd5Hash(property.AddressLine + property.Suburb + property.State + property.PostCode)
+
+    for i := 0; i < len(holders); i++ {
+        account := Account{ID: holders[i].Entity, Role: ROLE_PRIVATE_ENTITY, Cash: 1000}
+        account.create(stub)
+        _, err = t.dispatch(stub, FUNCTION_INVOKE, "transfer", []string{exchange.ID, issuerID, holders[i].Entity, propertyID, strconv.Itoa(holders[i].Units)})
+        if checkErrors(err){return "", err}
+    }
+    return propertyID, nil
+}
+
+//testTradeMapMigration - a trade in an old trade map blob gets its own key and the blob is deleted
+func (t *SimpleChaincode ) testTradeMapMigration(stub *shim.ChaincodeStub) []string {
+    var responses []string
+
+    trade := Trade{ID: "testmigratetrade", AccountID: "testmigrateaccount", PropertyID: "testmigrateproperty", Direction: TRADE_SELL, Price: 1, Units: 1}
+    bytes, _ := json.Marshal(TradeMap{Trades: map[string]Trade{trade.ID: trade}})
+    stub.PutState(ACCT_TRADES_PREFIX + trade.AccountID, bytes)
+    stub.PutState(PRPTY_TRADES_PREFIX + trade.PropertyID, bytes)
+
+    _, err := t.migrateTradeMaps(stub, Args{})
+    accountTrades, err2 := getAccountTrades(stub, trade.AccountID)
+    propertyTrades, err3 := getPropertyTrades(stub, trade.PropertyID)
+    blob, _ := stub.GetState(ACCT_TRADES_PREFIX + trade.AccountID)
+    if !checkErrors(err) && !checkErrors(err2) && !checkErrors(err3) && len(accountTrades) == 1 && len(propertyTrades) == 1 && blob == nil {
+        responses = append(responses, "COMPLETE: Trade maps migrated to trade keys")
+    } else {
+        responses = append(responses, "FAIL: trade maps should be migrated to trade keys")
+    }
+    return responses
+}
+
+func (t *SimpleChaincode ) testStopTrigger() []string {
+    var responses []string
+
+    sell := Trade{Direction: TRADE_SELL, OrderType: ORDER_STOP, StopPrice: 95}
+    buy := Trade{Direction: TRADE_BUY, OrderType: ORDER_STOP_LIMIT, StopPrice: 105}
+    if sell.stopTriggered(95) && !sell.stopTriggered(96) && !sell.stopTriggered(0) && buy.stopTriggered(110) && !buy.stopTriggered(100) {
+        responses = append(responses, "COMPLETE: Stops trigger when the last price reaches the stop price")
+    } else {
+        responses = append(responses, "FAIL: stops should trigger when the last price reaches the stop price")
+    }
+    return responses
+}
+
+func (t *SimpleChaincode ) testEquilibriumPrice() []string {
+    var responses []string
+
+    buys := []Trade{{Price: 105, Units: 100}, {Price: 100, Units: 50}}
+    sells := []Trade{{Price: 98, Units: 80}, {Price: 102, Units: 60}}
+    lowest, _ := equilibriumPrice(buys, sells, 0)
+    nearest, volume := equilibriumPrice(buys, sells, 104)
+    if lowest == 102 && nearest == 105 && volume == 100 {
+        responses = append(responses, "COMPLETE: The opening auction picks the price that trades the most units")
+    } else {
+        responses = append(responses, "FAIL: the opening auction should pick the price that trades the most units")
+    }
+    return responses
+}
+
+func (t *SimpleChaincode ) testProposalTally() []string {
+    var responses []string
+
+    proposal := Proposal{Options: []string{"a", "b", "c"}, QuorumPct: 50, Units: 100, Snapshot: map[string]int{"x": 30, "y": 30, "z": 10}}
+    proposal.Votes = map[string]string{"x": "a", "y": "b"}
+    proposal.tally()
+    tied := proposal.QuorumMet && proposal.Outcome == ""
+    proposal.Votes["z"] = "b"
+    proposal.tally()
+    if tied && proposal.Outcome == "b" && proposal.Voted == 70 {
+        responses = append(responses, "COMPLETE: Proposals are won by the option with the most units and a tie has no outcome")
+    } else {
+        responses = append(responses, "FAIL: proposals should be won by the option with the most units and a tie should have no outcome")
+    }
+    return responses
+}
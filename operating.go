@@ -0,0 +1,351 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const   OPERATING_INCOME        = "INCOME"
+const   OPERATING_EXPENSE       = "EXPENSE"
+const   OPERATING_DISTRIBUTION  = "DISTRIBUTION"
+
+var expenseCategories = []string{"RATES", "STRATA", "REPAIRS", "INSURANCE", "UTILITIES", "OTHER"}
+
+//==============================================================================================================================
+//    OperatingEntry - Cash in or out of a property's operating account, the account keyed by the property ID. Rent
+//                     comes in as income, expenses go out against a category and the hash of their receipt, and what
+//                     is left is distributed to the holders
+//==============================================================================================================================
+type OperatingEntry struct {
+    ID              string      `json:"entryID"`
+    PropertyID      string      `json:"propertyID"`
+    Type            string      `json:"type"`
+    Category        string      `json:"category,omitempty"`
+    Amount          float64     `json:"amount"`
+    ReceiptHash     string      `json:"receiptHash,omitempty"`
+    Reference       string      `json:"reference,omitempty"`
+    RecordedBy      string      `json:"recordedBy"`
+    Timestamp       int64       `json:"timestamp"`
+    Version         int         `json:"version"`
+}
+
+//==============================================================================================================================
+//    PnL - What getPropertyPnL returns for a calendar year or month in UTC
+//==============================================================================================================================
+type PnL struct {
+    PropertyID      string              `json:"propertyID"`
+    Period          string              `json:"period"`
+    From            int64               `json:"from"`
+    To              int64               `json:"to"`
+    Income          float64             `json:"income"`
+    Expenses        map[string]float64  `json:"expenses"`
+    TotalExpenses   float64             `json:"totalExpenses"`
+    NetIncome       float64             `json:"netIncome"`
+    Distributed     float64             `json:"distributed"`
+    Cash            float64             `json:"cash"`
+}
+
+//==============================================================================================================================
+//     Query Logic Methods
+//==============================================================================================================================
+//     getPropertyPnL - Income, expenses by category and distributions for the period, formatted 2006 or 2006-01
+//==============================================================================================================================
+func (t *SimpleChaincode) getPropertyPnL(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    propertyID := args.String("propertyID")
+
+    var pnl PnL
+    var err error
+    pnl.PropertyID = propertyID
+    pnl.Period = args.String("period")
+    pnl.From, pnl.To, err = parsePeriod(pnl.Period)
+    if checkErrors(err){return nil, err}
+    pnl.Expenses = map[string]float64{}
+
+    propertyAccount, err := getAccount(stub, propertyID)
+    if checkErrors(err){return nil, err}
+    pnl.Cash = propertyAccount.Cash
+
+    entries, err := getOperatingEntries(stub, propertyID, pnl.From, pnl.To)
+    if checkErrors(err){return nil, err}
+
+    for i := 0; i < len(entries); i++ {
+        switch entries[i].Type {
+            case OPERATING_INCOME:
+                pnl.Income += entries[i].Amount
+            case OPERATING_EXPENSE:
+                pnl.Expenses[entries[i].Category] += entries[i].Amount
+                pnl.TotalExpenses += entries[i].Amount
+            case OPERATING_DISTRIBUTION:
+                pnl.Distributed += entries[i].Amount
+        }
+    }
+    pnl.Income = roundCents(pnl.Income)
+    pnl.TotalExpenses = roundCents(pnl.TotalExpenses)
+    pnl.NetIncome = roundCents(pnl.Income - pnl.TotalExpenses)
+    pnl.Distributed = roundCents(pnl.Distributed)
+
+    bytes, err := json.Marshal(pnl)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling profit and loss", err)}
+    return bytes, nil
+}
+
+//==============================================================================================================================
+//     Invoke Logic Methods
+//==============================================================================================================================
+//     receiveRent - The manager pays rent they have collected into the property's operating account
+//==============================================================================================================================
+func (t *SimpleChaincode) receiveRent(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    amount := args.Float("amount")
+    if amount <= 0 {return nil, newError(ERR_INVALID_ARGUMENT, "Rent must be positive")}
+
+    property, manager, err := getManagedProperty(stub, args.String("managerID"), args.String("propertyID"))
+    if checkErrors(err){return nil, err}
+
+    if manager.Cash < amount {return nil, newError(ERR_INSUFFICIENT_FUNDS, "Not enough cash to pay in the rent")}
+    manager.Cash -= amount
+    err = manager.save(stub)
+    if checkErrors(err){return nil, err}
+    err = postLedgerEntry(stub, manager, LEDGER_RENT, -amount, 0, property.ID)
+    if checkErrors(err){return nil, err}
+
+    entry := OperatingEntry{PropertyID: property.ID, Type: OPERATING_INCOME, Amount: amount, Reference: args.String("reference"), RecordedBy: manager.ID}
+    err = entry.post(stub, LEDGER_RENT, amount)
+    if checkErrors(err){return nil, err}
+
+    err = property.accrueManagementFee(stub, amount)
+    if checkErrors(err){return nil, err}
+    err = property.save(stub)
+    if checkErrors(err){return nil, err}
+
+    log.info("Received rent", "propertyID", property.ID, "amount", amount)
+    return entry.marshal()
+}
+
+//==============================================================================================================================
+//     recordExpense - The manager pays an expense out of the operating account. The cash goes to the manager, who pays
+//                     the supplier
+//==============================================================================================================================
+func (t *SimpleChaincode) recordExpense(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    amount := args.Float("amount")
+    category := args.String("category")
+    if amount <= 0 {return nil, newError(ERR_INVALID_ARGUMENT, "Expense must be positive")}
+    if !validExpenseCategory(category) {return nil, newError(ERR_INVALID_ARGUMENT, "Invalid expense category " + category)}
+
+    property, manager, err := getManagedProperty(stub, args.String("managerID"), args.String("propertyID"))
+    if checkErrors(err){return nil, err}
+
+    entry := OperatingEntry{PropertyID: property.ID, Type: OPERATING_EXPENSE, Category: category, Amount: amount, ReceiptHash: args.String("receiptHash"), RecordedBy: manager.ID}
+    err = entry.post(stub, LEDGER_EXPENSE, -amount)
+    if checkErrors(err){return nil, err}
+
+    manager, err = getAccount(stub, manager.ID)
+    if checkErrors(err){return nil, err}
+    manager.Cash += amount
+    err = manager.save(stub)
+    if checkErrors(err){return nil, err}
+    err = postLedgerEntry(stub, manager, LEDGER_EXPENSE, amount, 0, property.ID)
+    if checkErrors(err){return nil, err}
+
+    log.info("Recorded expense", "propertyID", property.ID, "category", category, "amount", amount)
+    return entry.marshal()
+}
+
+//==============================================================================================================================
+//     distributeIncome - Pay the manager's accrued fee, then the operating account's cash, or the amount given, to the
+//                        holders pro rata, or to the holders of record when a snapshot is given. Each payment is rounded
+//                        down to the cent and the remainder stays in the account
+//==============================================================================================================================
+func (t *SimpleChaincode) distributeIncome(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    property, manager, err := getManagedProperty(stub, args.String("managerID"), args.String("propertyID"))
+    if checkErrors(err){return nil, err}
+    if property.Units <= 0 {return nil, newError(ERR_STATE_VIOLATION, "Property " + property.ID + " has no units")}
+
+    err = property.settleManagementFee(stub)
+    if checkErrors(err){return nil, err}
+    err = property.save(stub)
+    if checkErrors(err){return nil, err}
+
+    holders, units, err := holdersOfRecord(stub, property, args.String("snapshotID"))
+    if checkErrors(err){return nil, err}
+
+    propertyAccount, err := getAccount(stub, property.ID)
+    if checkErrors(err){return nil, err}
+
+    amount := propertyAccount.Cash
+    if args.Has("amount") {amount = args.Float("amount")}
+    if amount <= 0 {return nil, newError(ERR_INVALID_ARGUMENT, "Nothing to distribute")}
+    if amount > propertyAccount.Cash {return nil, newError(ERR_INSUFFICIENT_FUNDS, "The operating account doesn't hold enough cash")}
+
+    payouts, err := distribute(stub, property, holders, units, amount, manager.ID, "")
+    if checkErrors(err){return nil, err}
+
+    bytes, err := json.Marshal(payouts)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling payouts", err)}
+    return bytes, nil
+}
+
+//==============================================================================================================================
+//     Operating Subroutines
+//==============================================================================================================================
+//     distribute - Pay amount out of the operating account to the holders pro rata, each rounded down to the cent. When
+//                  residualTo is given the rounding remainder is paid to that account too, otherwise it stays behind
+//==============================================================================================================================
+func distribute(stub *shim.ChaincodeStub, property Property, holders []Holding, units int, amount float64, recordedBy string, residualTo string) ([]Payout, error) {
+    payouts := []Payout{}
+    var total float64
+    for i := 0; i < len(holders) && units > 0; i++ {
+        payout := Payout{AccountID: holders[i].Entity, Units: holders[i].Units}
+        payout.Amount = floorCents(amount * float64(payout.Units) / float64(units))
+        if payout.Amount == 0 {continue}
+
+        holder, err := getAccount(stub, payout.AccountID)
+        if checkErrors(err){return nil, err}
+        holder.Cash += payout.Amount
+        err = holder.save(stub)
+        if checkErrors(err){return nil, err}
+        err = postLedgerEntry(stub, holder, LEDGER_DISTRIBUTION, payout.Amount, 0, property.ID)
+        if checkErrors(err){return nil, err}
+
+        total += payout.Amount
+        payouts = append(payouts, payout)
+    }
+
+    residual := roundCents(amount - total)
+    if residualTo != "" && residual > 0 {
+        account, err := getAccount(stub, residualTo)
+        if checkErrors(err){return nil, err}
+        account.Cash += residual
+        err = account.save(stub)
+        if checkErrors(err){return nil, err}
+        err = postLedgerEntry(stub, account, LEDGER_DISTRIBUTION, residual, 0, property.ID + ":" + account.ID)
+        if checkErrors(err){return nil, err}
+
+        total += residual
+        payouts = append(payouts, Payout{AccountID: account.ID, Amount: residual})
+    }
+
+    entry := OperatingEntry{PropertyID: property.ID, Type: OPERATING_DISTRIBUTION, Amount: roundCents(total), RecordedBy: recordedBy}
+    err := entry.post(stub, LEDGER_DISTRIBUTION, -entry.Amount)
+    if checkErrors(err){return nil, err}
+
+    log.info("Distributed income", "propertyID", property.ID, "amount", entry.Amount, "holders", len(payouts))
+    return payouts, nil
+}
+
+//==============================================================================================================================
+//     closeOperatingAccount - Before a property closes its manager is paid the fee they have accrued and the rest of the
+//                             operating account goes to the holders, with the rounding remainder to the manager, so no
+//                             cash is left behind
+//==============================================================================================================================
+func closeOperatingAccount(stub *shim.ChaincodeStub, property *Property, recordedBy string) error {
+    err := property.settleManagementFee(stub)
+    if checkErrors(err){return err}
+
+    propertyAccount, err := getAccount(stub, property.ID)
+    if checkErrors(err){return err}
+    if propertyAccount.Cash <= 0 {return nil}
+
+    _, err = distribute(stub, *property, propertyAccount.Holdings, property.Units, propertyAccount.Cash, recordedBy, property.manager())
+    return err
+}
+
+//==============================================================================================================================
+//     getManagedProperty - The property and its manager's account, which must be the caller. A property without a
+//                          manager is managed by its issuer
+//==============================================================================================================================
+func getManagedProperty(stub *shim.ChaincodeStub, managerID string, propertyID string) (Property, Account, error) {
+    property, err := getProperty(stub, propertyID)
+    if checkErrors(err){return property, Account{}, err}
+    if property.closed() {return property, Account{}, newError(ERR_STATE_VIOLATION, "Property " + property.ID + " is no longer trading")}
+    if managerID != property.manager() {return property, Account{}, newError(ERR_UNAUTHORISED, "Only the manager can run property " + property.ID + "'s operating account")}
+
+    manager, err := getAccount(stub, managerID)
+    return property, manager, err
+}
+
+func (object *Property) manager() string {
+    if object.ManagedBy != "" {return object.ManagedBy}
+    return object.Issuer
+}
+
+func validExpenseCategory(category string) bool {
+    for i := 0; i < len(expenseCategories); i++ {
+        if expenseCategories[i] == category {return true}
+    }
+    return false
+}
+
+//parsePeriod - the start and end of a calendar year or month in UTC
+func parsePeriod(period string) (int64, int64, error) {
+    if month, err := time.Parse("2006-01", period); err == nil {
+        return month.Unix(), month.AddDate(0, 1, 0).Unix(), nil
+    }
+    if year, err := time.Parse("2006", period); err == nil {
+        return year.Unix(), year.AddDate(1, 0, 0).Unix(), nil
+    }
+    return 0, 0, newError(ERR_INVALID_ARGUMENT, "Period must be a year or month formatted 2006 or 2006-01")
+}
+
+func floorCents(value float64) float64 {
+    return float64(int64(value * 100 + 1e-6)) / 100
+}
+
+//==============================================================================================================================
+//     CRUD Subroutines
+//==============================================================================================================================
+//     post - Move delta in or out of the operating account and record the entry against it. An accrual moves no cash
+//==============================================================================================================================
+func (object *OperatingEntry) post(stub *shim.ChaincodeStub, entryType string, delta float64) error {
+    var err error
+    object.Timestamp, err = getTxTime(stub)
+    if checkErrors(err){return err}
+    object.ID = getMd5Hash(getTxID(stub) + object.PropertyID + object.Type)
+    if delta == 0 {return operatingEntries.create(stub, object)}
+
+    propertyAccount, err := getAccount(stub, object.PropertyID)
+    if checkErrors(err){return err}
+    propertyAccount.Cash = roundCents(propertyAccount.Cash + delta)
+    if propertyAccount.Cash < 0 {return newError(ERR_INSUFFICIENT_FUNDS, "The operating account doesn't hold enough cash")}
+
+    err = propertyAccount.save(stub)
+    if checkErrors(err){return err}
+    err = postLedgerEntry(stub, propertyAccount, entryType, delta, 0, object.ID)
+    if checkErrors(err){return err}
+
+    return operatingEntries.create(stub, object)
+}
+
+//getOperatingEntries - the property's entries from from up to but not including to
+func getOperatingEntries(stub *shim.ChaincodeStub, propertyID string, from int64, to int64) ([]OperatingEntry, error) {
+    objects := []OperatingEntry{}
+
+    ids, err := operatingEntries.listBetween(stub, "property", indexValue(propertyID, fmt.Sprintf("%019d", from)), indexValue(propertyID, fmt.Sprintf("%019d", to)))
+    if checkErrors(err){return nil, err}
+
+    for i := 0; i < len(ids); i++ {
+        var object OperatingEntry
+        err = operatingEntries.get(stub, ids[i], &object)
+        if checkErrors(err){return nil, err}
+        objects = append(objects, object)
+    }
+    return objects, nil
+}
+
+func (object *OperatingEntry) getID() string {return object.ID}
+func (object *OperatingEntry) getVersion() int {return object.Version}
+func (object *OperatingEntry) setVersion(version int) {object.Version = version}
+
+func (object *OperatingEntry) indexes() map[string][]string {
+    return map[string][]string{"property": {indexValue(object.PropertyID, fmt.Sprintf("%019d", object.Timestamp))}}
+}
+
+//==============================================================================================================================
+//     Parsing Subroutines
+//==============================================================================================================================
+func (object *OperatingEntry) marshal() ([]byte, error) {
+    bytes, err := json.Marshal(object)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling operating entry", err)}
+    return bytes, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+    "fmt"
+    "math"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//    TradingRules - A property's tick size and minimum lot. A zero tick size falls back to the configured one, a zero
+//                   minimum lot allows any number of units
+//==============================================================================================================================
+type TradingRules struct {
+    TickSize        float64     `json:"tickSize"`
+    MinLot          int         `json:"minLot"`
+}
+
+//==============================================================================================================================
+//    PriceBand - New trades must be priced within Pct percent of the property's reference price, the last traded price
+//                or else the valuation per unit. Zero means no band. Outside the band a trade is rejected, or when
+//                HaltSeconds is set it is cancelled and the property halted for that long
+//==============================================================================================================================
+type PriceBand struct {
+    Pct             float64     `json:"pct"`
+    HaltSeconds     int64       `json:"haltSeconds"`
+}
+
+//==============================================================================================================================
+//     Invoke Logic Methods
+//==============================================================================================================================
+//     setTradingRules - Set a property's tick size and minimum lot. Trades already on the book are left alone. The
+//                       registry makes sure only the exchange can do this
+//==============================================================================================================================
+func (t *SimpleChaincode) setTradingRules(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    rules := *args.Body("rules").(*TradingRules)
+
+    err := rules.validate()
+    if checkErrors(err){return nil, err}
+
+    property, err := getProperty(stub, args.String("propertyID"))
+    if checkErrors(err){return nil, err}
+
+    property.TickSize = rules.TickSize
+    property.MinLot = rules.MinLot
+    err = property.save(stub)
+    if checkErrors(err){return nil, err}
+
+    log.info("Set trading rules", "propertyID", property.ID, "tickSize", rules.TickSize, "minLot", rules.MinLot)
+    return nil, nil
+}
+
+func (object *TradingRules) validate() error {
+    if object.TickSize < 0 {return newError(ERR_INVALID_ARGUMENT, "Tick size can't be negative")}
+    if object.MinLot < 0 {return newError(ERR_INVALID_ARGUMENT, "Minimum lot can't be negative")}
+    return nil
+}
+
+func (object *PriceBand) validate() error {
+    if object.Pct < 0 || object.Pct > 100 {return newError(ERR_INVALID_ARGUMENT, "Price band must be between 0 and 100 percent")}
+    if object.HaltSeconds < 0 {return newError(ERR_INVALID_ARGUMENT, "Price band halt can't be negative")}
+    return nil
+}
+
+//==============================================================================================================================
+//     Price Checks
+//==============================================================================================================================
+//     checkTrade - Apply the property's tick size and minimum lot to a new trade
+//==============================================================================================================================
+func (object *Property) checkTrade(trade Trade) error {
+    tickSize := object.tickSize()
+    if !onTick(trade.Price, tickSize) {return newError(ERR_INVALID_ARGUMENT, "Price isn't a multiple of the tick size " + fmt.Sprint(tickSize))}
+    if !onTick(trade.StopPrice, tickSize) {return newError(ERR_INVALID_ARGUMENT, "Stop price isn't a multiple of the tick size " + fmt.Sprint(tickSize))}
+    if trade.Units < object.MinLot {return newError(ERR_INVALID_ARGUMENT, "Trade is smaller than the minimum lot of " + fmt.Sprint(object.MinLot))}
+    return nil
+}
+
+func (object *Property) tickSize() float64 {
+    if object.TickSize > 0 {return object.TickSize}
+    return config.TickSize
+}
+
+//onTick - whether the price is a whole number of ticks
+func onTick(price float64, tickSize float64) bool {
+    if tickSize == 0 {return true}
+
+    ticks := price / tickSize
+    return math.Abs(ticks - math.Floor(ticks + 0.5)) < 1e-9
+}
+
+//referencePrice - the last traded price, or the valuation per unit before the property has traded
+func (object *Property) referencePrice() float64 {
+    if object.LastPrice > 0 {return object.LastPrice}
+    if object.Units > 0 {return object.Valuation / float64(object.Units)}
+    return 0
+}
+
+//inBand - whether the price is within the band around the reference price. Market trades have no price to check
+func (object *Property) inBand(band PriceBand, price float64) bool {
+    reference := object.referencePrice()
+    if band.Pct == 0 || price == 0 || reference == 0 {return true}
+
+    return math.Abs(price - reference) <= reference * band.Pct / 100 + 1e-9
+}
+
+//isHalted - halted by the exchange, or by the price band until HaltedUntil
+func (object *Property) isHalted(now int64) bool {
+    return object.Halted || now < object.HaltedUntil
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+    "math"
+    "sort"
+    "strconv"
+    "strings"
+    "reflect"
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const   FUNCTION_QUERY      = "query"
+const   FUNCTION_INVOKE     = "invoke"
+
+const   ARG_STRING          = "string"
+const   ARG_INT             = "int"
+const   ARG_FLOAT           = "float"
+const   ARG_JSON            = "json"
+
+//==============================================================================================================================
+//    Handler - Every query and invoke is called with its arguments already parsed against its FunctionSpec
+//==============================================================================================================================
+type Handler func(t *SimpleChaincode, stub *shim.ChaincodeStub, args Args) ([]byte, error)
+
+//==============================================================================================================================
+//    FunctionSpec - A registered chaincode function. When Roles is set the account named by the first argument must
+//                   hold one of those roles
+//==============================================================================================================================
+type FunctionSpec struct {
+    Name            string      `json:"name"`
+    Kind            string      `json:"kind"`
+    Args            []ArgSpec   `json:"args"`
+    Roles           []int       `json:"roles,omitempty"`
+    handler         Handler
+}
+
+//==============================================================================================================================
+//    ArgSpec - A single argument. JSON arguments are unmarshalled into a new value from body, whose type name is
+//              reported as Body. A variadic argument must come last and collects the remaining arguments. An optional
+//              argument passed as an empty string is treated as not passed, so later arguments can still be given
+//==============================================================================================================================
+type ArgSpec struct {
+    Name            string      `json:"name"`
+    Type            string      `json:"type"`
+    Body            string      `json:"body,omitempty"`
+    Optional        bool        `json:"optional,omitempty"`
+    Variadic        bool        `json:"variadic,omitempty"`
+    body            func() interface{}
+}
+
+//==============================================================================================================================
+//    FunctionDescription - What describeFunctions returns, with the fields of each JSON body spelled out
+//==============================================================================================================================
+type FunctionDescription struct {
+    FunctionSpec
+    Bodies          map[string][]FieldDescription   `json:"bodies,omitempty"`
+}
+
+type FieldDescription struct {
+    Name            string      `json:"name"`
+    Type            string      `json:"type"`
+}
+
+//==============================================================================================================================
+//    Args - Parsed arguments keyed by name
+//==============================================================================================================================
+type Args struct {
+    values          map[string]interface{}
+}
+
+var functions = map[string]FunctionSpec{}
+
+//register - every invoke takes an optional client request ID as its last argument, which makes it idempotent
+func register(spec FunctionSpec) {
+    if spec.Kind == FUNCTION_INVOKE {
+        spec.Args = append(spec.Args, ArgSpec{Name: REQUEST_ID_ARG, Type: ARG_STRING, Optional: true})
+    }
+    functions[spec.Name] = spec
+}
+
+//==============================================================================================================================
+//     Registry - Every query and invoke the chaincode exposes
+//==============================================================================================================================
+func init() {
+    //queries
+    register(FunctionSpec{Name: "login", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).login,
+        Args: []ArgSpec{{Name: "accountID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "getAccount", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getAccount,
+        Args: []ArgSpec{{Name: "accountID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "getProperties", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getProperties,
+        Args: []ArgSpec{{Name: "propertyIDs", Type: ARG_STRING, Variadic: true}}})
+    register(FunctionSpec{Name: "getOpenTradesByAccount", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getOpenTradesByAccount,
+        Args: []ArgSpec{{Name: "accountID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "getAvailableTrades", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getAvailableTrades})
+    register(FunctionSpec{Name: "getFeeSchedule", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getFeeSchedule})
+    register(FunctionSpec{Name: "getConfig", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getConfig})
+    register(FunctionSpec{Name: "getLedger", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getLedger,
+        Args: []ArgSpec{{Name: "accountID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "listAccounts", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).listAccounts,
+        Args: []ArgSpec{{Name: "pageSize", Type: ARG_INT, Optional: true}, {Name: "bookmark", Type: ARG_STRING, Optional: true}, {Name: "status", Type: ARG_INT, Optional: true}}})
+    register(FunctionSpec{Name: "listProperties", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).listProperties,
+        Args: []ArgSpec{{Name: "pageSize", Type: ARG_INT, Optional: true}, {Name: "bookmark", Type: ARG_STRING, Optional: true}, {Name: "status", Type: ARG_INT, Optional: true}}})
+    register(FunctionSpec{Name: "listTrades", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).listTrades,
+        Args: []ArgSpec{{Name: "pageSize", Type: ARG_INT, Optional: true}, {Name: "bookmark", Type: ARG_STRING, Optional: true}, {Name: "direction", Type: ARG_STRING, Optional: true}, {Name: "status", Type: ARG_INT, Optional: true}}})
+    register(FunctionSpec{Name: "getSurveillanceAlerts", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getSurveillanceAlerts, Roles: []int{ROLE_EXCHANGE},
+        Args: []ArgSpec{{Name: "exchangeID", Type: ARG_STRING}, {Name: "propertyID", Type: ARG_STRING}, {Name: "window", Type: ARG_INT}}})
+    register(FunctionSpec{Name: "getLimitUsage", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getLimitUsage,
+        Args: []ArgSpec{{Name: "accountID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "getCashRequest", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getCashRequest,
+        Args: []ArgSpec{{Name: "requestID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "getCashRequests", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getCashRequests,
+        Args: []ArgSpec{{Name: "accountID", Type: ARG_STRING}, {Name: "status", Type: ARG_INT, Optional: true}}})
+    register(FunctionSpec{Name: "getPendingCashRequests", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getPendingCashRequests, Roles: []int{ROLE_EXCHANGE},
+        Args: []ArgSpec{{Name: "exchangeID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "getRequestOutcome", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getRequestOutcome,
+        Args: []ArgSpec{{Name: REQUEST_ID_ARG, Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "getSaleStatement", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getSaleStatement,
+        Args: []ArgSpec{{Name: "propertyID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "getPropertyPnL", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getPropertyPnL,
+        Args: []ArgSpec{{Name: "propertyID", Type: ARG_STRING}, {Name: "period", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "getManagedProperties", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getManagedProperties,
+        Args: []ArgSpec{{Name: "managerID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "getProposal", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getProposal,
+        Args: []ArgSpec{{Name: "proposalID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "getProposals", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getProposals,
+        Args: []ArgSpec{{Name: "propertyID", Type: ARG_STRING}, {Name: "status", Type: ARG_INT, Optional: true}}})
+    register(FunctionSpec{Name: "getSnapshot", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getSnapshot,
+        Args: []ArgSpec{{Name: "snapshotID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "getSnapshots", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getSnapshots,
+        Args: []ArgSpec{{Name: "propertyID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "getUnderwriting", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getUnderwriting,
+        Args: []ArgSpec{{Name: "offerID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "getUnderwritings", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getUnderwritings,
+        Args: []ArgSpec{{Name: "underwriterID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "getOffers", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getOffers,
+        Args: []ArgSpec{{Name: "propertyID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "getMarket", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).getMarket})
+    register(FunctionSpec{Name: "describeFunctions", Kind: FUNCTION_QUERY, handler: (*SimpleChaincode).describeFunctions})
+
+    //invokes
+    register(FunctionSpec{Name: "depositCash", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).depositCash, Roles: []int{ROLE_EXCHANGE},
+        Args: []ArgSpec{{Name: "exchangeID", Type: ARG_STRING}, {Name: "accountID", Type: ARG_STRING}, {Name: "value", Type: ARG_FLOAT}}})
+    register(FunctionSpec{Name: "withdrawCash", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).withdrawCash, Roles: []int{ROLE_EXCHANGE},
+        Args: []ArgSpec{{Name: "exchangeID", Type: ARG_STRING}, {Name: "accountID", Type: ARG_STRING}, {Name: "value", Type: ARG_FLOAT}}})
+    register(FunctionSpec{Name: "requestDeposit", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).requestDeposit,
+        Args: []ArgSpec{{Name: "accountID", Type: ARG_STRING}, {Name: "amount", Type: ARG_FLOAT}, {Name: "bankReference", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "requestWithdrawal", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).requestWithdrawal,
+        Args: []ArgSpec{{Name: "accountID", Type: ARG_STRING}, {Name: "amount", Type: ARG_FLOAT}, {Name: "bankReference", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "confirmCashRequest", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).confirmCashRequest, Roles: []int{ROLE_EXCHANGE},
+        Args: []ArgSpec{{Name: "exchangeID", Type: ARG_STRING}, {Name: "requestID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "rejectCashRequest", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).rejectCashRequest, Roles: []int{ROLE_EXCHANGE},
+        Args: []ArgSpec{{Name: "exchangeID", Type: ARG_STRING}, {Name: "requestID", Type: ARG_STRING}, {Name: "reason", Type: ARG_STRING, Optional: true}}})
+    register(FunctionSpec{Name: "createTrade", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).createTrade,
+        Args: []ArgSpec{{Name: "trade", Type: ARG_JSON, Body: "Trade", body: func() interface{} {return &Trade{}}}}})
+    register(FunctionSpec{Name: "expireTrades", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).expireTrades,
+        Args: []ArgSpec{{Name: "propertyID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "createAccount", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).createAccount,
+        Args: []ArgSpec{{Name: "accountID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "setAccountRole", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).setAccountRole, Roles: []int{ROLE_ADMIN},
+        Args: []ArgSpec{{Name: "adminID", Type: ARG_STRING}, {Name: "accountID", Type: ARG_STRING}, {Name: "role", Type: ARG_INT}}})
+    register(FunctionSpec{Name: "issueProperty", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).issueProperty,
+        Args: []ArgSpec{{Name: "property", Type: ARG_JSON, Body: "Property", body: func() interface{} {return &Property{}}}}})
+    register(FunctionSpec{Name: "generateOffer", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).generateOffer,
+        Args: []ArgSpec{{Name: "issuerID", Type: ARG_STRING}, {Name: "propertyID", Type: ARG_STRING}, {Name: "units", Type: ARG_INT}, {Name: "offerPeriod", Type: ARG_INT, Optional: true},
+            {Name: "minFloorPrice", Type: ARG_FLOAT, Optional: true}}})
+    register(FunctionSpec{Name: "acceptOffer", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).acceptOffer,
+        Args: []ArgSpec{{Name: "offerID", Type: ARG_STRING}, {Name: "accountID", Type: ARG_STRING}, {Name: "units", Type: ARG_INT, Optional: true}}})
+    register(FunctionSpec{Name: "underwriteOffer", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).underwriteOffer, Roles: []int{ROLE_MARKET_MAKER},
+        Args: []ArgSpec{{Name: "underwriterID", Type: ARG_STRING}, {Name: "offerID", Type: ARG_STRING}, {Name: "units", Type: ARG_INT}, {Name: "floorPrice", Type: ARG_FLOAT}}})
+    register(FunctionSpec{Name: "closeOffer", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).closeOffer,
+        Args: []ArgSpec{{Name: "offerID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "setConfig", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).setConfig, Roles: []int{ROLE_ADMIN},
+        Args: []ArgSpec{{Name: "adminID", Type: ARG_STRING}, {Name: "config", Type: ARG_JSON, Body: "Configuration", body: func() interface{} {
+            configuration := defaultConfiguration()
+            return &configuration
+        }}}})
+    register(FunctionSpec{Name: "reclaimProperty", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).reclaimProperty,
+        Args: []ArgSpec{{Name: "accountID", Type: ARG_STRING}, {Name: "propertyID", Type: ARG_STRING}, {Name: "pricePerUnit", Type: ARG_FLOAT}}})
+    register(FunctionSpec{Name: "settlePropertySale", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).settlePropertySale,
+        Args: []ArgSpec{{Name: "managerID", Type: ARG_STRING}, {Name: "propertyID", Type: ARG_STRING}, {Name: "grossProceeds", Type: ARG_FLOAT}, {Name: "costs", Type: ARG_FLOAT}}})
+    register(FunctionSpec{Name: "splitUnits", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).splitUnits,
+        Args: []ArgSpec{{Name: "accountID", Type: ARG_STRING}, {Name: "propertyID", Type: ARG_STRING}, {Name: "ratioNum", Type: ARG_INT}, {Name: "ratioDen", Type: ARG_INT}}})
+    register(FunctionSpec{Name: "issueUnits", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).issueUnits,
+        Args: []ArgSpec{{Name: "issuerID", Type: ARG_STRING}, {Name: "propertyID", Type: ARG_STRING}, {Name: "units", Type: ARG_INT}, {Name: "price", Type: ARG_FLOAT, Optional: true},
+            {Name: "rightsPeriod", Type: ARG_INT, Optional: true}, {Name: "snapshotID", Type: ARG_STRING, Optional: true}}})
+    register(FunctionSpec{Name: "receiveRent", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).receiveRent,
+        Args: []ArgSpec{{Name: "managerID", Type: ARG_STRING}, {Name: "propertyID", Type: ARG_STRING}, {Name: "amount", Type: ARG_FLOAT}, {Name: "reference", Type: ARG_STRING, Optional: true}}})
+    register(FunctionSpec{Name: "recordExpense", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).recordExpense,
+        Args: []ArgSpec{{Name: "managerID", Type: ARG_STRING}, {Name: "propertyID", Type: ARG_STRING}, {Name: "amount", Type: ARG_FLOAT}, {Name: "category", Type: ARG_STRING},
+            {Name: "receiptHash", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "distributeIncome", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).distributeIncome,
+        Args: []ArgSpec{{Name: "managerID", Type: ARG_STRING}, {Name: "propertyID", Type: ARG_STRING}, {Name: "amount", Type: ARG_FLOAT, Optional: true},
+            {Name: "snapshotID", Type: ARG_STRING, Optional: true}}})
+    register(FunctionSpec{Name: "assignManager", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).assignManager,
+        Args: []ArgSpec{{Name: "issuerID", Type: ARG_STRING}, {Name: "propertyID", Type: ARG_STRING}, {Name: "managerAccountID", Type: ARG_STRING}, {Name: "feeBps", Type: ARG_INT},
+            {Name: "startDate", Type: ARG_INT}}})
+    register(FunctionSpec{Name: "nominateManager", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).nominateManager,
+        Args: []ArgSpec{{Name: "holderID", Type: ARG_STRING}, {Name: "propertyID", Type: ARG_STRING}, {Name: "managerAccountID", Type: ARG_STRING}, {Name: "feeBps", Type: ARG_INT},
+            {Name: "startDate", Type: ARG_INT}, {Name: "closesAt", Type: ARG_INT}, {Name: "snapshotID", Type: ARG_STRING, Optional: true}}})
+    register(FunctionSpec{Name: "payManagementFee", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).payManagementFee,
+        Args: []ArgSpec{{Name: "managerID", Type: ARG_STRING}, {Name: "propertyID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "createProposal", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).createProposal,
+        Args: []ArgSpec{{Name: "accountID", Type: ARG_STRING}, {Name: "propertyID", Type: ARG_STRING}, {Name: "description", Type: ARG_STRING},
+            {Name: "options", Type: ARG_JSON, Body: "[]string", body: func() interface{} {return &[]string{}}}, {Name: "closesAt", Type: ARG_INT}, {Name: "quorumPct", Type: ARG_FLOAT},
+            {Name: "snapshotID", Type: ARG_STRING, Optional: true}}})
+    register(FunctionSpec{Name: "castVote", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).castVote,
+        Args: []ArgSpec{{Name: "proposalID", Type: ARG_STRING}, {Name: "accountID", Type: ARG_STRING}, {Name: "option", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "closeProposal", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).closeProposal,
+        Args: []ArgSpec{{Name: "proposalID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "takeSnapshot", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).takeSnapshot,
+        Args: []ArgSpec{{Name: "accountID", Type: ARG_STRING}, {Name: "propertyID", Type: ARG_STRING}, {Name: "label", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "transfer", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).transfer, Roles: []int{ROLE_EXCHANGE},
+        Args: []ArgSpec{{Name: "exchangeID", Type: ARG_STRING}, {Name: "fromAccountID", Type: ARG_STRING}, {Name: "toAccountID", Type: ARG_STRING}, {Name: "propertyID", Type: ARG_STRING}, {Name: "units", Type: ARG_INT}}})
+    register(FunctionSpec{Name: "setAccountLimits", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).setAccountLimits, Roles: []int{ROLE_ADMIN},
+        Args: []ArgSpec{{Name: "adminID", Type: ARG_STRING}, {Name: "accountID", Type: ARG_STRING},
+            {Name: "limits", Type: ARG_JSON, Body: "AccountLimits", Optional: true, body: func() interface{} {return &AccountLimits{}}}}})
+    register(FunctionSpec{Name: "migrateTradeMaps", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).migrateTradeMaps, Roles: []int{ROLE_ADMIN},
+        Args: []ArgSpec{{Name: "adminID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "purgeRequestRecords", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).purgeRequestRecords, Roles: []int{ROLE_ADMIN},
+        Args: []ArgSpec{{Name: "adminID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "setSession", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).setSession, Roles: []int{ROLE_EXCHANGE},
+        Args: []ArgSpec{{Name: "exchangeID", Type: ARG_STRING}, {Name: "state", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "setCalendar", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).setCalendar, Roles: []int{ROLE_EXCHANGE},
+        Args: []ArgSpec{{Name: "exchangeID", Type: ARG_STRING}, {Name: "calendar", Type: ARG_JSON, Body: "MarketCalendar", body: func() interface{} {return &MarketCalendar{}}}}})
+    register(FunctionSpec{Name: "haltProperty", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).haltProperty, Roles: []int{ROLE_EXCHANGE},
+        Args: []ArgSpec{{Name: "exchangeID", Type: ARG_STRING}, {Name: "propertyID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "resumeProperty", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).resumeProperty, Roles: []int{ROLE_EXCHANGE},
+        Args: []ArgSpec{{Name: "exchangeID", Type: ARG_STRING}, {Name: "propertyID", Type: ARG_STRING}}})
+    register(FunctionSpec{Name: "setTradingRules", Kind: FUNCTION_INVOKE, handler: (*SimpleChaincode).setTradingRules, Roles: []int{ROLE_EXCHANGE},
+        Args: []ArgSpec{{Name: "exchangeID", Type: ARG_STRING}, {Name: "propertyID", Type: ARG_STRING}, {Name: "rules", Type: ARG_JSON, Body: "TradingRules", body: func() interface{} {return &TradingRules{}}}}})
+}
+
+//==============================================================================================================================
+//     dispatch - Look up the function, parse and validate its arguments, check the caller's role then run it
+//==============================================================================================================================
+func (t *SimpleChaincode) dispatch(stub *shim.ChaincodeStub, kind string, function string, args []string) ([]byte, error) {
+    spec, found := functions[function]
+    if !found || spec.Kind != kind {return nil, newError(ERR_INVALID_ARGUMENT, "Invalid function (" + function + ") called")}
+
+    parsed, err := spec.parse(args)
+    if checkErrors(err){return nil, err}
+
+    if len(spec.Roles) > 0 {
+        _, err = checkAccountRole(stub, parsed.String(spec.Args[0].Name), spec.Roles...)
+        if checkErrors(err){return nil, err}
+    }
+
+    if spec.Kind == FUNCTION_INVOKE && parsed.Has(REQUEST_ID_ARG) {return t.dispatchOnce(stub, spec, args, parsed)}
+    return spec.handler(t, stub, parsed)
+}
+
+//==============================================================================================================================
+//     describeFunctions - The registry, so clients can generate their bindings
+//==============================================================================================================================
+func (t *SimpleChaincode) describeFunctions(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    var names []string
+    for name := range functions {names = append(names, name)}
+    sort.Strings(names)
+
+    descriptions := []FunctionDescription{}
+    for i := 0; i < len(names); i++ {
+        var description FunctionDescription
+        description.FunctionSpec = functions[names[i]]
+        if description.Args == nil {description.Args = []ArgSpec{}}
+
+        for j := 0; j < len(description.Args); j++ {
+            arg := description.Args[j]
+            if arg.body == nil {continue}
+            if description.Bodies == nil {description.Bodies = map[string][]FieldDescription{}}
+            description.Bodies[arg.Body] = describeFields(reflect.TypeOf(arg.body()).Elem())
+        }
+        descriptions = append(descriptions, description)
+    }
+
+    bytes, err := json.Marshal(descriptions)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling function descriptions", err)}
+    return bytes, nil
+}
+
+//describeFields - the JSON fields of a struct body. Other bodies, like a plain array, have none
+func describeFields(structType reflect.Type) []FieldDescription {
+    fields := []FieldDescription{}
+    if structType.Kind() != reflect.Struct {return fields}
+    for i := 0; i < structType.NumField(); i++ {
+        field := structType.Field(i)
+        name := strings.Split(field.Tag.Get("json"), ",")[0]
+        if field.PkgPath != "" || name == "" || name == "-" {continue}
+
+        fieldType := field.Type.Kind().String()
+        switch field.Type.Kind() {
+            case reflect.Int, reflect.Int64:
+                fieldType = ARG_INT
+            case reflect.Float64:
+                fieldType = ARG_FLOAT
+            case reflect.Struct, reflect.Map:
+                fieldType = "object"
+            case reflect.Slice:
+                fieldType = "array"
+        }
+        fields = append(fields, FieldDescription{Name: name, Type: fieldType})
+    }
+    return fields
+}
+
+//==============================================================================================================================
+//     Argument Parsing
+//==============================================================================================================================
+func (spec *FunctionSpec) parse(args []string) (Args, error) {
+    parsed := Args{values: map[string]interface{}{}}
+
+    var required int
+    variadic := false
+    for i := 0; i < len(spec.Args); i++ {
+        if spec.Args[i].Variadic {variadic = true} else if !spec.Args[i].Optional {required++}
+    }
+    if len(args) < required || (!variadic && len(args) > len(spec.Args)) {
+        return parsed, newError(ERR_INVALID_ARGUMENT, "Incorrect number of arguments passed, expecting " + spec.usage())
+    }
+
+    for i := 0; i < len(spec.Args) && i < len(args); i++ {
+        arg := spec.Args[i]
+        if arg.Variadic {
+            parsed.values[arg.Name] = args[i:]
+            break
+        }
+        if arg.Optional && args[i] == "" {continue}
+
+        value, err := arg.parse(args[i])
+        if checkErrors(err){return parsed, err}
+        parsed.values[arg.Name] = value
+    }
+
+    return parsed, nil
+}
+
+func (spec *FunctionSpec) usage() string {
+    var names []string
+    for i := 0; i < len(spec.Args); i++ {
+        name := spec.Args[i].Name + " " + spec.Args[i].Type
+        if spec.Args[i].Optional {name += " optional"}
+        if spec.Args[i].Variadic {name += "..."}
+        names = append(names, name)
+    }
+    return spec.Name + "(" + strings.Join(names, ", ") + ")"
+}
+
+func (arg *ArgSpec) parse(text string) (interface{}, error) {
+    switch arg.Type {
+        case ARG_INT:
+            value, err := strconv.Atoi(text)
+            if checkErrors(err){return nil, wrapError(ERR_INVALID_ARGUMENT, "Could not parse " + arg.Name + " " + text + " to int", err)}
+            return value, nil
+        case ARG_FLOAT:
+            value, err := strconv.ParseFloat(text, 64)
+            if checkErrors(err){return nil, wrapError(ERR_INVALID_ARGUMENT, "Could not parse " + arg.Name + " " + text + " to float", err)}
+            if math.IsNaN(value) || math.IsInf(value, 0) {return nil, newError(ERR_INVALID_ARGUMENT, arg.Name + " must be a finite number")}
+            return value, nil
+        case ARG_JSON:
+            value := arg.body()
+            err := json.Unmarshal([]byte(text), value)
+            if checkErrors(err){return nil, wrapError(ERR_INVALID_ARGUMENT, "Invalid " + arg.Name, err)}
+            return value, nil
+        default:
+            if text == "" {return nil, newError(ERR_INVALID_ARGUMENT, arg.Name + " is required")}
+            return text, nil
+    }
+}
+
+func (a Args) Has(name string) bool {
+    _, found := a.values[name]
+    return found
+}
+
+func (a Args) String(name string) string {
+    value, _ := a.values[name].(string)
+    return value
+}
+
+func (a Args) Strings(name string) []string {
+    value, _ := a.values[name].([]string)
+    return value
+}
+
+func (a Args) Int(name string) int {
+    value, _ := a.values[name].(int)
+    return value
+}
+
+func (a Args) Float(name string) float64 {
+    value, _ := a.values[name].(float64)
+    return value
+}
+
+//Body - the unmarshalled JSON argument, a pointer to the spec's body type
+func (a Args) Body(name string) interface{} {
+    return a.values[name]
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+    "strconv"
+    "strings"
+    "reflect"
+    "encoding/base64"
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const   INDEX_PREFIX        = "idx:"
+
+//==============================================================================================================================
+//    Entity - Anything stored through a Repository. The version is checked and bumped on every save so a stale copy
+//             can't overwrite a newer one
+//==============================================================================================================================
+type Entity interface {
+    getID() string
+    getVersion() int
+    setVersion(version int)
+}
+
+//==============================================================================================================================
+//    Indexed - Entities that maintain secondary indexes return index name -> values. Index keys are rewritten
+//              whenever the values change
+//==============================================================================================================================
+type Indexed interface {
+    indexes() map[string][]string
+}
+
+//==============================================================================================================================
+//    SoftDeletable - Entities that are kept on the ledger when deleted, with their status changed instead
+//==============================================================================================================================
+type SoftDeletable interface {
+    markDeleted()
+}
+
+//==============================================================================================================================
+//    Repository - Stores one type of entity under Prefix + ID
+//==============================================================================================================================
+type Repository struct {
+    Name            string
+    Prefix          string
+}
+
+//only the version is needed to check a save
+type versionOnly struct {
+    Version         int         `json:"version"`
+}
+
+var properties      = Repository{Name: "Property", Prefix: PROPERTY_PREFIX}
+var accounts        = Repository{Name: "Account", Prefix: ACCOUNT_PREFIX}
+var trades          = Repository{Name: "Trade", Prefix: TRADE_PREFIX}
+var offers          = Repository{Name: "Offer", Prefix: OFFER_PREFIX}
+var executions      = Repository{Name: "Execution", Prefix: EXECUTION_PREFIX}
+var ledgerEntries   = Repository{Name: "Ledger entry", Prefix: LEDGER_PREFIX}
+var cashRequests    = Repository{Name: "Cash request", Prefix: CASH_REQUEST_PREFIX}
+var requestRecords  = Repository{Name: "Request record", Prefix: REQUEST_PREFIX}
+var saleStatements  = Repository{Name: "Sale statement", Prefix: SALE_PREFIX}
+var operatingEntries = Repository{Name: "Operating entry", Prefix: OPERATING_PREFIX}
+var proposals       = Repository{Name: "Proposal", Prefix: PROPOSAL_PREFIX}
+var snapshots       = Repository{Name: "Snapshot", Prefix: SNAPSHOT_PREFIX}
+var underwritings   = Repository{Name: "Underwriting", Prefix: UNDERWRITING_PREFIX}
+
+//==============================================================================================================================
+//     get - Load the entity into object. A missing key is NOT_FOUND, a record that won't unmarshal is INTERNAL
+//==============================================================================================================================
+func (r *Repository) get(stub *shim.ChaincodeStub, id string, object Entity) error {
+    bytes, err := stub.GetState(r.Prefix + id)
+    if checkErrors(err){return wrapError(ERR_INTERNAL, "Couldn't retrieve " + r.Name + " for " + id, err)}
+    if bytes == nil {return newError(ERR_NOT_FOUND, r.Name + " " + id + " doesn't exist")}
+
+    err = json.Unmarshal(bytes, object)
+    if checkErrors(err){return wrapError(ERR_INTERNAL, "Error unmarshalling " + r.Name + " " + id, err)}
+
+    return nil
+}
+
+func (r *Repository) exists(stub *shim.ChaincodeStub, id string) (bool, error) {
+    bytes, err := stub.GetState(r.Prefix + id)
+    if checkErrors(err){return false, wrapError(ERR_INTERNAL, "Couldn't retrieve " + r.Name + " for " + id, err)}
+    return bytes != nil, nil
+}
+
+//==============================================================================================================================
+//     create - Save a new entity, failing if one already exists with the ID
+//==============================================================================================================================
+func (r *Repository) create(stub *shim.ChaincodeStub, object Entity) error {
+    if object.getID() == "" {return newError(ERR_INVALID_ARGUMENT, r.Name + " needs an ID")}
+
+    found, err := r.exists(stub, object.getID())
+    if checkErrors(err){return err}
+    if found {return newError(ERR_CONFLICT, r.Name + " " + object.getID() + " already exists")}
+
+    object.setVersion(0)
+    return r.save(stub, object)
+}
+
+//==============================================================================================================================
+//     save - Write the entity if nobody has saved it since it was read, bumping the version and rewriting any index
+//            keys whose values have changed
+//==============================================================================================================================
+func (r *Repository) save(stub *shim.ChaincodeStub, object Entity) error {
+    id := object.getID()
+    if id == "" {return newError(ERR_INVALID_ARGUMENT, r.Name + " needs an ID")}
+
+    old, err := stub.GetState(r.Prefix + id)
+    if checkErrors(err){return wrapError(ERR_INTERNAL, "Couldn't retrieve " + r.Name + " for " + id, err)}
+
+    var stored versionOnly
+    if old != nil {
+        err = json.Unmarshal(old, &stored)
+        if checkErrors(err){return wrapError(ERR_INTERNAL, "Error unmarshalling " + r.Name + " " + id, err)}
+    }
+    if stored.Version != object.getVersion() {
+        return newError(ERR_CONFLICT, r.Name + " " + id + " was modified at version " + strconv.Itoa(stored.Version) + " since it was read at version " + strconv.Itoa(object.getVersion()))
+    }
+
+    err = r.updateIndexes(stub, object, old)
+    if checkErrors(err){return err}
+
+    object.setVersion(object.getVersion() + 1)
+    bytes, err := json.Marshal(object)
+    if checkErrors(err){return wrapError(ERR_INTERNAL, "Error marshalling " + r.Name + " " + id, err)}
+
+    err = stub.PutState(r.Prefix + id, bytes)
+    if checkErrors(err){return wrapError(ERR_INTERNAL, "Couldn't save " + r.Name + " for " + id, err)}
+
+    return nil
+}
+
+//==============================================================================================================================
+//     delete - Soft deletable entities are marked deleted and saved, anything else is removed along with its index keys
+//==============================================================================================================================
+func (r *Repository) delete(stub *shim.ChaincodeStub, object Entity) error {
+    if deletable, ok := object.(SoftDeletable); ok {
+        deletable.markDeleted()
+        return r.save(stub, object)
+    }
+
+    id := object.getID()
+    old, err := stub.GetState(r.Prefix + id)
+    if checkErrors(err){return wrapError(ERR_INTERNAL, "Couldn't retrieve " + r.Name + " for " + id, err)}
+    if old == nil {return newError(ERR_NOT_FOUND, r.Name + " " + id + " doesn't exist")}
+
+    if indexed, ok := object.(Indexed); ok {
+        previous, err := r.previousIndexes(object, old)
+        if checkErrors(err){return err}
+        err = r.writeIndexes(stub, id, previous, indexed.indexes(), true)
+        if checkErrors(err){return err}
+    }
+
+    err = stub.DelState(r.Prefix + id)
+    if checkErrors(err){return wrapError(ERR_INTERNAL, "Couldn't delete " + r.Name + " for " + id, err)}
+
+    return nil
+}
+
+//==============================================================================================================================
+//     list - The IDs filed under the index value, in key order
+//==============================================================================================================================
+func (r *Repository) list(stub *shim.ChaincodeStub, index string, value string) ([]string, error) {
+    prefix := indexValue(value) + ":"
+    return r.listBetween(stub, index, prefix, rangeEnd(prefix))
+}
+
+//listBetween - the IDs filed under index values from start up to but not including end. Build start and end with indexValue
+func (r *Repository) listBetween(stub *shim.ChaincodeStub, index string, start string, end string) ([]string, error) {
+    ids := []string{}
+    prefix := r.indexPrefix(index)
+
+    iterator, err := stub.RangeQueryState(prefix + start, prefix + end)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Couldn't retrieve " + r.Name + " index " + index, err)}
+    defer iterator.Close()
+
+    for iterator.HasNext() {
+        _, bytes, err := iterator.Next()
+        if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Couldn't retrieve " + r.Name + " index " + index, err)}
+        ids = append(ids, string(bytes))
+    }
+
+    return ids, nil
+}
+
+//==============================================================================================================================
+//     page - Walk the records in ID order from the bookmark, handing each to add until it has taken pageSize of them.
+//            The bookmark is the last ID taken, encoded so clients treat it as opaque, and is empty when nothing is left
+//==============================================================================================================================
+func (r *Repository) page(stub *shim.ChaincodeStub, bookmark string, pageSize int, add func(bytes []byte) (bool, error)) (string, error) {
+    start := r.Prefix
+    if bookmark != "" {
+        id, err := base64.URLEncoding.DecodeString(bookmark)
+        if checkErrors(err){return "", wrapError(ERR_INVALID_ARGUMENT, "Invalid bookmark", err)}
+        start = r.Prefix + string(id) + "\x00"
+    }
+
+    iterator, err := stub.RangeQueryState(start, rangeEnd(r.Prefix))
+    if checkErrors(err){return "", wrapError(ERR_INTERNAL, "Couldn't list " + r.Name, err)}
+    defer iterator.Close()
+
+    var last string
+    var taken int
+    for taken < pageSize && iterator.HasNext() {
+        key, bytes, err := iterator.Next()
+        if checkErrors(err){return "", wrapError(ERR_INTERNAL, "Couldn't list " + r.Name, err)}
+
+        added, err := add(bytes)
+        if checkErrors(err){return "", err}
+        if added {
+            taken++
+            last = key[len(r.Prefix):]
+        }
+    }
+
+    if taken < pageSize || !iterator.HasNext() {return "", nil}
+    return base64.URLEncoding.EncodeToString([]byte(last)), nil
+}
+
+//==============================================================================================================================
+//     Index Maintenance - Each index entry is keyed idx:<prefix><index>:<value>:<id> and holds the ID
+//==============================================================================================================================
+func (r *Repository) indexPrefix(index string) string {
+    return INDEX_PREFIX + r.Prefix + index + ":"
+}
+
+//indexValue - join the parts of an index value with ":". Each part is escaped first so an ID containing ":" can't
+//             collide with the prefix of another ID, or with the separator before a timestamp part
+var indexEscaper = strings.NewReplacer("%", "%25", ":", "%3A")
+
+func indexValue(parts ...string) string {
+    for i := 0; i < len(parts); i++ {parts[i] = indexEscaper.Replace(parts[i])}
+    return strings.Join(parts, ":")
+}
+
+func (r *Repository) updateIndexes(stub *shim.ChaincodeStub, object Entity, old []byte) error {
+    indexed, ok := object.(Indexed)
+    if !ok {return nil}
+
+    previous := map[string][]string{}
+    if old != nil {
+        var err error
+        previous, err = r.previousIndexes(object, old)
+        if checkErrors(err){return err}
+    }
+    current := indexed.indexes()
+
+    //remove what is no longer indexed then add what is new
+    removed := map[string][]string{}
+    added := map[string][]string{}
+    for index, values := range previous {removed[index] = subtract(values, current[index])}
+    for index, values := range current {added[index] = subtract(values, previous[index])}
+
+    err := r.writeIndexes(stub, object.getID(), removed, nil, true)
+    if checkErrors(err){return err}
+    return r.writeIndexes(stub, object.getID(), added, nil, false)
+}
+
+//previousIndexes - unmarshal the stored record into a new value of the same type to get its index values
+func (r *Repository) previousIndexes(object Entity, old []byte) (map[string][]string, error) {
+    previous := reflect.New(reflect.TypeOf(object).Elem()).Interface()
+    err := json.Unmarshal(old, previous)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error unmarshalling " + r.Name + " " + object.getID(), err)}
+
+    indexed, ok := previous.(Indexed)
+    if !ok {return map[string][]string{}, nil}
+    return indexed.indexes(), nil
+}
+
+func (r *Repository) writeIndexes(stub *shim.ChaincodeStub, id string, first map[string][]string, second map[string][]string, remove bool) error {
+    for _, entries := range []map[string][]string{first, second} {
+        for index, values := range entries {
+            for i := 0; i < len(values); i++ {
+                key := r.indexPrefix(index) + values[i] + ":" + id
+                var err error
+                if remove {
+                    err = stub.DelState(key)
+                } else {
+                    err = stub.PutState(key, []byte(id))
+                }
+                if checkErrors(err){return wrapError(ERR_INTERNAL, "Couldn't update " + r.Name + " index " + index, err)}
+            }
+        }
+    }
+    return nil
+}
+
+//subtract - the values in a that aren't in b
+func subtract(a []string, b []string) []string {
+    var result []string
+    for i := 0; i < len(a); i++ {
+        found := false
+        for j := 0; j < len(b) && !found; j++ {found = a[i] == b[j]}
+        if !found {result = append(result, a[i])}
+    }
+    return result
+}
+
+//==============================================================================================================================
+//     rangeEnd - The end key for a range query over everything starting with prefix
+//==============================================================================================================================
+func rangeEnd(prefix string) string {
+    return prefix + "\xff"
+}
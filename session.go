@@ -0,0 +1,401 @@
+package main
+
+import (
+    "sort"
+    "time"
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const   SESSION_PRE_OPEN    = "PRE_OPEN"
+const   SESSION_CONTINUOUS  = "CONTINUOUS"
+const   SESSION_CLOSED      = "CLOSED"
+const   SESSION_HALTED      = "HALTED"
+
+const   MARKET_KEY          = "market"
+
+//==============================================================================================================================
+//    Market - The trading session the exchange has put the market in, and the calendar of days it can trade on.
+//             Limit trades entered during PRE_OPEN rest without matching until the opening auction uncrosses them
+//==============================================================================================================================
+type Market struct {
+    State           string          `json:"state"`
+    Since           int64           `json:"since"`
+    Calendar        MarketCalendar  `json:"calendar"`
+}
+
+//==============================================================================================================================
+//    MarketCalendar - TradingDays are weekdays, 0 for Sunday, and every day trades when empty. Holidays are UTC dates
+//                     formatted 2006-01-02. The hours of a trading day are in the configuration
+//==============================================================================================================================
+type MarketCalendar struct {
+    TradingDays     []int       `json:"tradingDays"`
+    Holidays        []string    `json:"holidays"`
+}
+
+//==============================================================================================================================
+//     Query Logic Methods
+//==============================================================================================================================
+//     getMarket
+//==============================================================================================================================
+func (t *SimpleChaincode) getMarket(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    market, err := getMarket(stub)
+    if checkErrors(err){return nil, err}
+
+    return market.marshal()
+}
+
+//==============================================================================================================================
+//     Invoke Logic Methods
+//==============================================================================================================================
+//     setSession - Move the market to a new session. Opening from PRE_OPEN runs the opening auction and returns its
+//                  executions. The registry makes sure only the exchange can do this
+//==============================================================================================================================
+func (t *SimpleChaincode) setSession(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    state := args.String("state")
+
+    market, err := getMarket(stub)
+    if checkErrors(err){return nil, err}
+    if !market.canMoveTo(state) {return nil, newError(ERR_STATE_VIOLATION, "Can't move the market from " + market.State + " to " + state)}
+
+    auction := market.State == SESSION_PRE_OPEN && state == SESSION_CONTINUOUS
+    market.State = state
+    market.Since, err = getTxTime(stub)
+    if checkErrors(err){return nil, err}
+
+    err = market.save(stub)
+    if checkErrors(err){return nil, err}
+    log.info("Moved market session", "state", state)
+
+    executions := []Execution{}
+    if auction {
+        executions, err = runOpeningAuction(stub)
+        if checkErrors(err){return nil, err}
+    }
+
+    bytes, err := json.Marshal(executions)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling executions", err)}
+    return bytes, nil
+}
+
+//==============================================================================================================================
+//     setCalendar - Replace the market calendar. The registry makes sure only the exchange can do this
+//==============================================================================================================================
+func (t *SimpleChaincode) setCalendar(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    calendar := *args.Body("calendar").(*MarketCalendar)
+
+    err := calendar.validate()
+    if checkErrors(err){return nil, err}
+
+    market, err := getMarket(stub)
+    if checkErrors(err){return nil, err}
+
+    market.Calendar = calendar
+    err = market.save(stub)
+    if checkErrors(err){return nil, err}
+
+    log.info("Updated market calendar")
+    return nil, nil
+}
+
+//==============================================================================================================================
+//     haltProperty / resumeProperty - Stop and restart trading in a single property. The registry makes sure only the
+//                                     exchange can do this
+//==============================================================================================================================
+func (t *SimpleChaincode) haltProperty(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    return nil, setPropertyHalted(stub, args.String("propertyID"), true)
+}
+
+func (t *SimpleChaincode) resumeProperty(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    return nil, setPropertyHalted(stub, args.String("propertyID"), false)
+}
+
+func setPropertyHalted(stub *shim.ChaincodeStub, propertyID string, halted bool) error {
+    property, err := getProperty(stub, propertyID)
+    if checkErrors(err){return err}
+    if property.Halted == halted && property.HaltedUntil == 0 {return nil}
+
+    //resuming also lifts a price band halt
+    property.Halted = halted
+    property.HaltedUntil = 0
+    err = property.save(stub)
+    if checkErrors(err){return err}
+
+    log.info("Set property halt", "propertyID", propertyID, "halted", halted)
+    return nil
+}
+
+//==============================================================================================================================
+//     CRUD Subroutines
+//==============================================================================================================================
+//     getMarket - A ledger that has never set a session trades continuously every day, as it always has
+//==============================================================================================================================
+func getMarket(stub *shim.ChaincodeStub) (Market, error) {
+    var object Market
+    object.State = SESSION_CONTINUOUS
+
+    bytes, err := stub.GetState(MARKET_KEY)
+    if checkErrors(err){return object, wrapError(ERR_INTERNAL, "Couldn't retrieve market", err)}
+    if bytes == nil {return object, nil}
+
+    err = json.Unmarshal(bytes, &object)
+    if checkErrors(err){return object, wrapError(ERR_INTERNAL, "Error unmarshalling market", err)}
+    return object, nil
+}
+
+func (object *Market) save(stub *shim.ChaincodeStub) error {
+    bytes, err := object.marshal()
+    if checkErrors(err){return err}
+
+    err = stub.PutState(MARKET_KEY, bytes)
+    if checkErrors(err){return wrapError(ERR_INTERNAL, "Couldn't save market", err)}
+
+    return nil
+}
+
+func (object *MarketCalendar) validate() error {
+    for i := 0; i < len(object.TradingDays); i++ {
+        if object.TradingDays[i] < 0 || object.TradingDays[i] > 6 {return newError(ERR_INVALID_ARGUMENT, "Trading days must be between 0 (Sunday) and 6")}
+    }
+    for i := 0; i < len(object.Holidays); i++ {
+        _, err := time.Parse("2006-01-02", object.Holidays[i])
+        if checkErrors(err){return wrapError(ERR_INVALID_ARGUMENT, "Invalid holiday " + object.Holidays[i], err)}
+    }
+    return nil
+}
+
+//==============================================================================================================================
+//     Session Checks
+//==============================================================================================================================
+//     canMoveTo - Any session can be halted and a halted market can go anywhere. Otherwise the day runs
+//                 CLOSED -> PRE_OPEN -> CONTINUOUS -> CLOSED, and a closed market can skip the auction
+//==============================================================================================================================
+func (object *Market) canMoveTo(state string) bool {
+    switch state {
+        case SESSION_HALTED:
+            return object.State != SESSION_HALTED
+        case SESSION_PRE_OPEN:
+            return object.State == SESSION_CLOSED || object.State == SESSION_HALTED
+        case SESSION_CONTINUOUS:
+            return object.State != SESSION_CONTINUOUS
+        case SESSION_CLOSED:
+            return object.State != SESSION_CLOSED
+    }
+    return false
+}
+
+func (object *MarketCalendar) isTradingDay(timestamp int64) bool {
+    day := time.Unix(timestamp, 0).UTC()
+
+    date := day.Format("2006-01-02")
+    for i := 0; i < len(object.Holidays); i++ {
+        if object.Holidays[i] == date {return false}
+    }
+
+    if len(object.TradingDays) == 0 {return true}
+    for i := 0; i < len(object.TradingDays); i++ {
+        if object.TradingDays[i] == int(day.Weekday()) {return true}
+    }
+    return false
+}
+
+//==============================================================================================================================
+//     checkTrade - Whether a new trade can be entered in the current session
+//==============================================================================================================================
+func (object *Market) checkTrade(trade Trade, property Property) error {
+    if property.isHalted(trade.Created) {return newError(ERR_STATE_VIOLATION, "Property " + property.ID + " is halted")}
+    if !object.Calendar.isTradingDay(trade.Created) {return newError(ERR_STATE_VIOLATION, "The market doesn't trade today")}
+
+    switch object.State {
+        case SESSION_CONTINUOUS:
+            return nil
+        case SESSION_PRE_OPEN:
+            if trade.marketable() || trade.TimeInForce == TIF_IOC || trade.TimeInForce == TIF_FOK {
+                return newError(ERR_INVALID_ARGUMENT, "Only limit trades that rest on the book can be entered before the open")
+            }
+            return nil
+        case SESSION_HALTED:
+            return newError(ERR_STATE_VIOLATION, "The market is halted")
+    }
+    return newError(ERR_STATE_VIOLATION, "The market is closed")
+}
+
+//checkOpen - whether executions can happen in the property right now
+func checkOpen(stub *shim.ChaincodeStub, property Property) error {
+    market, err := getMarket(stub)
+    if checkErrors(err){return err}
+    now, err := getTxTime(stub)
+    if checkErrors(err){return err}
+
+    if property.isHalted(now) {return newError(ERR_STATE_VIOLATION, "Property " + property.ID + " is halted")}
+    if market.State != SESSION_CONTINUOUS {return newError(ERR_STATE_VIOLATION, "The market isn't in continuous trading")}
+    return nil
+}
+
+//==============================================================================================================================
+//     Opening Auction
+//==============================================================================================================================
+//     runOpeningAuction - Uncross the book of every property that isn't halted, then let the opening prices trigger stops
+//==============================================================================================================================
+func runOpeningAuction(stub *shim.ChaincodeStub) ([]Execution, error) {
+    executions := []Execution{}
+
+    now, err := getTxTime(stub)
+    if checkErrors(err){return nil, err}
+    propertyIDs, err := getTradingProperties(stub)
+    if checkErrors(err){return nil, err}
+
+    for i := 0; i < len(propertyIDs); i++ {
+        property, err := getProperty(stub, propertyIDs[i])
+        if checkErrors(err){return nil, err}
+        if property.isHalted(now) {continue}
+
+        uncrossed, err := uncross(stub, property)
+        if checkErrors(err){return nil, err}
+        if len(uncrossed) == 0 {continue}
+        executions = append(executions, uncrossed...)
+
+        triggered, err := triggerStops(stub, property.ID)
+        if checkErrors(err){return nil, err}
+        executions = append(executions, triggered...)
+    }
+
+    return executions, nil
+}
+
+//==============================================================================================================================
+//     uncross - Execute every crossing buy and sell at the single price that trades the most units. Neither side
+//               took liquidity so both pay the maker fee. An account's buys are never filled by its own sells
+//==============================================================================================================================
+func uncross(stub *shim.ChaincodeStub, property Property) ([]Execution, error) {
+    var executions []Execution
+
+    now, err := getTxTime(stub)
+    if checkErrors(err){return nil, err}
+
+    resting, err := getPropertyTrades(stub, property.ID)
+    if checkErrors(err){return nil, err}
+
+    var buys, sells []Trade
+    for i := 0; i < len(resting); i++ {
+        if resting[i].Status != TRADE_STATE_OPEN {continue}
+        if resting[i].expired(now) {
+            err = resting[i].cancel(stub, TRADE_STATE_EXPIRED)
+            if checkErrors(err){return nil, err}
+            continue
+        }
+
+        if resting[i].Direction == TRADE_BUY {
+            buys = append(buys, resting[i])
+        } else {
+            sells = append(sells, resting[i])
+        }
+    }
+    sort.Sort(priceTimeOrder(buys))
+    sort.Sort(priceTimeOrder(sells))
+
+    price, volume := equilibriumPrice(buys, sells, property.LastPrice)
+    if volume == 0 {return nil, nil}
+    log.info("Uncrossing opening auction", "propertyID", property.ID, "price", price, "volume", volume)
+
+    schedule := config.Fees
+    filled := map[string]bool{}
+    for i := 0; i < len(buys) && volume > 0 && buys[i].Price >= price; i++ {
+        buy := &buys[i]
+        for j := 0; j < len(sells) && volume > 0 && buy.Units > 0 && sells[j].Price <= price; j++ {
+            sell := &sells[j]
+            if sell.Units == 0 || sell.AccountID == buy.AccountID {continue}
+
+            units := buy.Units
+            if sell.Units < units {units = sell.Units}
+            if volume < units {units = volume}
+
+            var execution Execution
+            execution.ID = getMd5Hash(getTxID(stub) + buy.ID + sell.ID)
+            execution.PropertyID = property.ID
+            execution.BuyTradeID = buy.ID
+            execution.SellTradeID = sell.ID
+            execution.BuyerID = buy.AccountID
+            execution.SellerID = sell.AccountID
+            execution.Price = price
+            execution.Units = units
+            execution.Timestamp = now
+
+            reserved := buy.Escrow
+            if units < buy.Units {reserved = buy.Escrow * float64(units) / float64(buy.Units)}
+            buy.Escrow -= reserved
+            buy.Units -= units
+            sell.Units -= units
+            volume -= units
+
+            err = settle(stub, schedule, &execution, reserved, true, true, true)
+            if checkErrors(err){return nil, err}
+
+            filled[buy.ID] = true
+            filled[sell.ID] = true
+            executions = append(executions, execution)
+        }
+    }
+
+    book := append(buys, sells...)
+    for i := 0; i < len(book); i++ {
+        if !filled[book[i].ID] {continue}
+
+        if book[i].Units == 0 {
+            err = book[i].remove(stub)
+        } else {
+            err = book[i].save(stub)
+        }
+        if checkErrors(err){return nil, err}
+    }
+
+    return executions, nil
+}
+
+//==============================================================================================================================
+//     equilibriumPrice - The limit price that trades the most units, then leaves the smallest imbalance, then is nearest
+//                        the reference price, then is lowest. Buys and sells must be in price time order
+//==============================================================================================================================
+func equilibriumPrice(buys []Trade, sells []Trade, reference float64) (float64, int) {
+    var bestPrice float64
+    bestVolume, bestImbalance := 0, 0
+
+    candidates := append(append([]Trade{}, buys...), sells...)
+    for i := 0; i < len(candidates); i++ {
+        price := candidates[i].Price
+
+        var demand, supply int
+        for j := 0; j < len(buys) && buys[j].Price >= price; j++ {demand += buys[j].Units}
+        for j := 0; j < len(sells) && sells[j].Price <= price; j++ {supply += sells[j].Units}
+
+        volume, imbalance := demand, supply - demand
+        if supply < demand {volume, imbalance = supply, demand - supply}
+        if volume == 0 {continue}
+
+        better := bestVolume == 0 || volume > bestVolume
+        if volume == bestVolume && imbalance != bestImbalance {better = imbalance < bestImbalance}
+        if volume == bestVolume && imbalance == bestImbalance && reference > 0 && distance(price, reference) != distance(bestPrice, reference) {
+            better = distance(price, reference) < distance(bestPrice, reference)
+        } else if volume == bestVolume && imbalance == bestImbalance {
+            better = price < bestPrice
+        }
+
+        if better {bestPrice, bestVolume, bestImbalance = price, volume, imbalance}
+    }
+
+    return bestPrice, bestVolume
+}
+
+func distance(a float64, b float64) float64 {
+    if a > b {return a - b}
+    return b - a
+}
+
+//==============================================================================================================================
+//     Parsing Subroutines
+//==============================================================================================================================
+func (object *Market) marshal() ([]byte, error) {
+    bytes, err := json.Marshal(object)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling market", err)}
+    return bytes, nil
+}
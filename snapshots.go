@@ -0,0 +1,132 @@
+package main
+
+import (
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//    Snapshot - A property's cap table frozen at a record date. Distributions, proposals and rights issues given a
+//               snapshot ID work from these holders of record instead of the live holdings. The ID is the hash of the
+//               property and label, kept apart, so each label is used once per property
+//==============================================================================================================================
+type Snapshot struct {
+    ID              string      `json:"snapshotID"`
+    PropertyID      string      `json:"propertyID"`
+    Label           string      `json:"label"`
+    TakenBy         string      `json:"takenBy"`
+    Taken           int64       `json:"taken"`
+    Units           int         `json:"units"`
+    Holdings        []Holding   `json:"holdings"`
+    Version         int         `json:"version"`
+}
+
+//==============================================================================================================================
+//     Query Logic Methods
+//==============================================================================================================================
+//     getSnapshot
+//==============================================================================================================================
+func (t *SimpleChaincode) getSnapshot(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    snapshot, err := getSnapshot(stub, args.String("snapshotID"))
+    if checkErrors(err){return nil, err}
+
+    return snapshot.marshal()
+}
+
+//==============================================================================================================================
+//     getSnapshots - Every snapshot taken of the property
+//==============================================================================================================================
+func (t *SimpleChaincode) getSnapshots(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    ids, err := snapshots.list(stub, "property", args.String("propertyID"))
+    if checkErrors(err){return nil, err}
+
+    objects := []Snapshot{}
+    for i := 0; i < len(ids); i++ {
+        snapshot, err := getSnapshot(stub, ids[i])
+        if checkErrors(err){return nil, err}
+        objects = append(objects, snapshot)
+    }
+
+    bytes, err := json.Marshal(objects)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling snapshot array", err)}
+    return bytes, nil
+}
+
+//==============================================================================================================================
+//     Invoke Logic Methods
+//==============================================================================================================================
+//     takeSnapshot - The issuer or manager records the holders of record. Units escrowed in open sell trades belong to
+//                    the seller until they trade, so they are counted with the seller's holding
+//==============================================================================================================================
+func (t *SimpleChaincode) takeSnapshot(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    snapshot := Snapshot{PropertyID: args.String("propertyID"), Label: args.String("label"), TakenBy: args.String("accountID")}
+
+    property, err := getProperty(stub, snapshot.PropertyID)
+    if checkErrors(err){return nil, err}
+    if property.closed() {return nil, newError(ERR_STATE_VIOLATION, "Property " + property.ID + " is no longer trading")}
+    if snapshot.TakenBy != property.Issuer && snapshot.TakenBy != property.ManagedBy {return nil, newError(ERR_UNAUTHORISED, "Only the issuer or manager can snapshot property " + property.ID)}
+
+    propertyAccount, err := getAccount(stub, property.ID)
+    if checkErrors(err){return nil, err}
+
+    snapshot.Holdings = []Holding{}
+    for i := 0; i < len(propertyAccount.Holdings); i++ {
+        if propertyAccount.Holdings[i].Units <= 0 {continue}
+        snapshot.Holdings = append(snapshot.Holdings, propertyAccount.Holdings[i])
+    }
+    snapshot.Units = property.Units
+    snapshot.ID = getMd5Hash(indexValue(snapshot.PropertyID, snapshot.Label))
+    snapshot.Taken, err = getTxTime(stub)
+    if checkErrors(err){return nil, err}
+
+    err = snapshots.create(stub, &snapshot)
+    if checkErrors(err){return nil, err}
+
+    log.info("Took snapshot", "snapshotID", snapshot.ID, "propertyID", snapshot.PropertyID, "label", snapshot.Label, "holders", len(snapshot.Holdings))
+    return snapshot.marshal()
+}
+
+//==============================================================================================================================
+//     Snapshot Subroutines
+//==============================================================================================================================
+//     holdersOfRecord - The holders and total units in the snapshot, or in the live cap table when no snapshot is given
+//==============================================================================================================================
+func holdersOfRecord(stub *shim.ChaincodeStub, property Property, snapshotID string) ([]Holding, int, error) {
+    if snapshotID == "" {
+        propertyAccount, err := getAccount(stub, property.ID)
+        if checkErrors(err){return nil, 0, err}
+        return append([]Holding{}, propertyAccount.Holdings...), property.Units, nil
+    }
+
+    snapshot, err := getSnapshot(stub, snapshotID)
+    if checkErrors(err){return nil, 0, err}
+    if snapshot.PropertyID != property.ID {return nil, 0, newError(ERR_INVALID_ARGUMENT, "Snapshot " + snapshotID + " is not of property " + property.ID)}
+
+    return snapshot.Holdings, snapshot.Units, nil
+}
+
+//==============================================================================================================================
+//     CRUD Subroutines
+//==============================================================================================================================
+func getSnapshot(stub *shim.ChaincodeStub, id string) (Snapshot, error) {
+    var object Snapshot
+    err := snapshots.get(stub, id, &object)
+    return object, err
+}
+
+func (object *Snapshot) getID() string {return object.ID}
+func (object *Snapshot) getVersion() int {return object.Version}
+func (object *Snapshot) setVersion(version int) {object.Version = version}
+
+func (object *Snapshot) indexes() map[string][]string {
+    return map[string][]string{"property": {indexValue(object.PropertyID)}}
+}
+
+//==============================================================================================================================
+//     Parsing Subroutines
+//==============================================================================================================================
+func (object *Snapshot) marshal() ([]byte, error) {
+    bytes, err := json.Marshal(object)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling snapshot", err)}
+    return bytes, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+    "sort"
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const   ALERT_SELF_TRADE    = "SELF_TRADE"
+const   ALERT_ROUND_TRIP    = "ROUND_TRIP"
+
+//==============================================================================================================================
+//    Alert - A suspicious pattern of executions. A round trip is two accounts buying from and then selling back to each
+//            other, a self trade is an account on both sides of one execution
+//==============================================================================================================================
+type Alert struct {
+    Type            string      `json:"type"`
+    PropertyID      string      `json:"propertyID"`
+    AccountIDs      []string    `json:"accountIDs"`
+    ExecutionIDs    []string    `json:"executionIDs"`
+    Timestamp       int64       `json:"timestamp"`
+}
+
+//==============================================================================================================================
+//    executionOrder - Sorts executions oldest first
+//==============================================================================================================================
+type executionOrder []Execution
+
+func (a executionOrder) Len() int      { return len(a) }
+func (a executionOrder) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a executionOrder) Less(i, j int) bool {
+    if a[i].Timestamp != a[j].Timestamp {return a[i].Timestamp < a[j].Timestamp}
+    return a[i].ID < a[j].ID
+}
+
+//==============================================================================================================================
+//     Query Logic Methods
+//==============================================================================================================================
+//     getSurveillanceAlerts - Flag the property's round trips completed within window seconds, and any self trades.
+//                             The registry makes sure only the exchange can run it
+//==============================================================================================================================
+func (t *SimpleChaincode) getSurveillanceAlerts(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    propertyID := args.String("propertyID")
+    window := int64(args.Int("window"))
+    if window < 0 {return nil, newError(ERR_INVALID_ARGUMENT, "Window can't be negative")}
+
+    executions, err := getPropertyExecutions(stub, propertyID)
+    if checkErrors(err){return nil, err}
+
+    alerts := []Alert{}
+    for i := 0; i < len(executions); i++ {
+        first := executions[i]
+        if first.BuyerID == first.SellerID {
+            alerts = append(alerts, Alert{Type: ALERT_SELF_TRADE, PropertyID: propertyID, AccountIDs: []string{first.BuyerID}, ExecutionIDs: []string{first.ID}, Timestamp: first.Timestamp})
+            continue
+        }
+
+        for j := i + 1; j < len(executions) && executions[j].Timestamp - first.Timestamp <= window; j++ {
+            second := executions[j]
+            if second.BuyerID != first.SellerID || second.SellerID != first.BuyerID {continue}
+
+            alerts = append(alerts, Alert{Type: ALERT_ROUND_TRIP, PropertyID: propertyID, AccountIDs: []string{first.BuyerID, first.SellerID}, ExecutionIDs: []string{first.ID, second.ID}, Timestamp: second.Timestamp})
+        }
+    }
+
+    bytes, err := json.Marshal(alerts)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling alerts", err)}
+    return bytes, nil
+}
+
+//==============================================================================================================================
+//     getPropertyExecutions - Every execution for the property, oldest first
+//==============================================================================================================================
+func getPropertyExecutions(stub *shim.ChaincodeStub, propertyID string) ([]Execution, error) {
+    objects := []Execution{}
+
+    ids, err := executions.list(stub, "property", propertyID)
+    if checkErrors(err){return nil, err}
+
+    for i := 0; i < len(ids); i++ {
+        var object Execution
+        err = executions.get(stub, ids[i], &object)
+        if checkErrors(err){return nil, err}
+        objects = append(objects, object)
+    }
+    sort.Sort(executionOrder(objects))
+
+    return objects, nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const   UNDERWRITING_OPEN       =  0
+const   UNDERWRITING_EXERCISED  =  1
+const   UNDERWRITING_RELEASED   =  2
+
+//==============================================================================================================================
+//    Underwriting - A market maker's commitment to take up to Units of an offer's unsold units at FloorPrice once it
+//                   closes. The cost and the taker fee on it are escrowed from the market maker's cash until then.
+//                   There is one commitment per offer, so its ID is the hash of the offer ID
+//==============================================================================================================================
+type Underwriting struct {
+    ID              string      `json:"underwritingID"`
+    OfferID         string      `json:"offerID"`
+    PropertyID      string      `json:"propertyID"`
+    UnderwriterID   string      `json:"underwriterID"`
+    Units           int         `json:"units"`
+    FloorPrice      float64     `json:"floorPrice"`
+    Escrow          float64     `json:"escrow"`
+    Taken           int         `json:"taken"`
+    ExecutionID     string      `json:"executionID,omitempty"`
+    Status          int         `json:"status"`
+    Created         int64       `json:"created"`
+    Resolved        int64       `json:"resolved,omitempty"`
+    Version         int         `json:"version"`
+}
+
+//==============================================================================================================================
+//     Query Logic Methods
+//==============================================================================================================================
+//     getUnderwriting - The commitment on the offer
+//==============================================================================================================================
+func (t *SimpleChaincode) getUnderwriting(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    underwriting, err := getUnderwriting(stub, underwritingID(args.String("offerID")))
+    if checkErrors(err){return nil, err}
+
+    return underwriting.marshal()
+}
+
+//==============================================================================================================================
+//     getUnderwritings - Every commitment the market maker has made
+//==============================================================================================================================
+func (t *SimpleChaincode) getUnderwritings(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    ids, err := underwritings.list(stub, "underwriter", args.String("underwriterID"))
+    if checkErrors(err){return nil, err}
+
+    objects := []Underwriting{}
+    for i := 0; i < len(ids); i++ {
+        underwriting, err := getUnderwriting(stub, ids[i])
+        if checkErrors(err){return nil, err}
+        objects = append(objects, underwriting)
+    }
+
+    bytes, err := json.Marshal(objects)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling underwriting array", err)}
+    return bytes, nil
+}
+
+//==============================================================================================================================
+//     Invoke Logic Methods
+//==============================================================================================================================
+//     underwriteOffer - A market maker commits to take up unsold units of an open offer, at no less than the minimum floor
+//                       price the seller set. The registry makes sure only a market maker can do this
+//==============================================================================================================================
+func (t *SimpleChaincode) underwriteOffer(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    underwriting := Underwriting{OfferID: args.String("offerID"), UnderwriterID: args.String("underwriterID"), Units: args.Int("units"), FloorPrice: args.Float("floorPrice")}
+
+    offer, err := getOffer(stub, underwriting.OfferID)
+    if checkErrors(err){return nil, err}
+    underwriting.PropertyID = offer.PropertyID
+
+    now, err := getTxTime(stub)
+    if checkErrors(err){return nil, err}
+    if offer.Buyer != "" {return nil, newError(ERR_INVALID_ARGUMENT, "Offer " + offer.ID + " is reserved for " + offer.Buyer)}
+    if offer.Expiry == 0 || offer.Expiry <= now {return nil, newError(ERR_STATE_VIOLATION, "Only an offer that closes in the future can be underwritten")}
+    if underwriting.UnderwriterID == offer.Seller {return nil, newError(ERR_INVALID_ARGUMENT, "The seller can't underwrite their own offer")}
+    if underwriting.Units <= 0 || underwriting.Units > offer.Units {return nil, newError(ERR_INVALID_ARGUMENT, "Must underwrite a positive number of the offer's units")}
+    if offer.MinFloorPrice <= 0 {return nil, newError(ERR_UNAUTHORISED, "The seller hasn't set a minimum floor price for offer " + offer.ID)}
+    if underwriting.FloorPrice < offer.MinFloorPrice || underwriting.FloorPrice > offer.Price {return nil, newError(ERR_INVALID_ARGUMENT, "Floor price must be between the offer's minimum floor price and its price")}
+
+    underwriter, err := getAccount(stub, underwriting.UnderwriterID)
+    if checkErrors(err){return nil, err}
+    err = checkNotProperty(stub, underwriter)
+    if checkErrors(err){return nil, err}
+    err = checkHoldingLimit(stub, underwriter, offer.PropertyID, underwriting.Units)
+    if checkErrors(err){return nil, err}
+
+    schedule := config.Fees
+    notional := roundCents(underwriting.FloorPrice * float64(underwriting.Units))
+    underwriting.Escrow = roundCents(notional + schedule.tradeFee(notional, underwriter.Role, false))
+    if underwriter.Cash < underwriting.Escrow {return nil, newError(ERR_INSUFFICIENT_FUNDS, "Not enough cash to underwrite the offer")}
+    underwriter.Cash -= underwriting.Escrow
+    err = underwriter.save(stub)
+    if checkErrors(err){return nil, err}
+
+    underwriting.ID = underwritingID(offer.ID)
+    underwriting.Status = UNDERWRITING_OPEN
+    underwriting.Created = now
+    err = underwritings.create(stub, &underwriting)
+    if checkErrors(err){return nil, err}
+
+    log.info("Underwrote offer", "offerID", offer.ID, "underwriterID", underwriter.ID, "units", underwriting.Units, "floorPrice", underwriting.FloorPrice)
+    return underwriting.marshal()
+}
+
+//==============================================================================================================================
+//     closeOffer - Once an offer has expired anyone can close it. The underwriter takes up as many of the unsold units as
+//                  they committed to at the floor price and gets back the rest of their escrow. Whatever is still unsold
+//                  stays with the seller. The take up waits for the market to be open, and if the seller can no longer
+//                  deliver or the underwriter can no longer hold the units the commitment is released instead
+//==============================================================================================================================
+func (t *SimpleChaincode) closeOffer(stub *shim.ChaincodeStub, args Args) ([]byte, error) {
+    offer, err := getOffer(stub, args.String("offerID"))
+    if checkErrors(err){return nil, err}
+
+    now, err := getTxTime(stub)
+    if checkErrors(err){return nil, err}
+    if offer.Expiry == 0 || offer.Expiry > now {return nil, newError(ERR_STATE_VIOLATION, "Offer " + offer.ID + " hasn't closed yet")}
+
+    underwriting, err := getUnderwriting(stub, underwritingID(offer.ID))
+    if isNotFound(err) {
+        log.info("Closed offer", "offerID", offer.ID, "unsold", offer.Units)
+        return nil, offer.withdraw(stub)
+    }
+    if checkErrors(err){return nil, err}
+
+    property, err := getProperty(stub, offer.PropertyID)
+    if checkErrors(err){return nil, err}
+    if property.closed() {return nil, newError(ERR_STATE_VIOLATION, "Property " + property.ID + " is no longer trading")}
+    err = checkOpen(stub, property)
+    if checkErrors(err){return nil, err}
+
+    unsold := offer.Units
+    underwriting.Taken = underwriting.Units
+    if unsold < underwriting.Taken {underwriting.Taken = unsold}
+
+    deliverable, err := underwriting.deliverable(stub, offer)
+    if checkErrors(err){return nil, err}
+    if !deliverable {
+        err = releaseUnderwriting(stub, offer.ID)
+        if checkErrors(err){return nil, err}
+        err = offer.withdraw(stub)
+        if checkErrors(err){return nil, err}
+
+        log.info("Closed offer", "offerID", offer.ID, "unsold", unsold)
+        underwriting, err = getUnderwriting(stub, underwriting.ID)
+        if checkErrors(err){return nil, err}
+        return underwriting.marshal()
+    }
+
+    //the whole escrow is reserved against the fill so settling it returns whatever the take up didn't use
+    execution, err := offer.fill(stub, underwriting.UnderwriterID, underwriting.Taken, underwriting.FloorPrice, underwriting.Escrow)
+    if checkErrors(err){return nil, err}
+    if offer.Units > 0 {
+        err = offer.withdraw(stub)
+        if checkErrors(err){return nil, err}
+    }
+
+    underwriting.ExecutionID = execution.ID
+    err = underwriting.resolve(stub, UNDERWRITING_EXERCISED)
+    if checkErrors(err){return nil, err}
+
+    log.info("Exercised underwriting", "offerID", offer.ID, "underwriterID", underwriting.UnderwriterID, "taken", underwriting.Taken, "unsold", unsold - underwriting.Taken)
+
+    _, err = triggerStops(stub, offer.PropertyID)
+    if checkErrors(err){return nil, err}
+    return underwriting.marshal()
+}
+
+//==============================================================================================================================
+//     Underwriting Subroutines
+//==============================================================================================================================
+//     releaseUnderwriting - Return the escrow on the offer's commitment, if it has one, because it will never be needed
+//==============================================================================================================================
+func releaseUnderwriting(stub *shim.ChaincodeStub, offerID string) error {
+    underwriting, err := getUnderwriting(stub, underwritingID(offerID))
+    if isNotFound(err) {return nil}
+    if checkErrors(err){return err}
+    if underwriting.Status != UNDERWRITING_OPEN {return nil}
+
+    underwriter, err := getAccount(stub, underwriting.UnderwriterID)
+    if checkErrors(err){return err}
+    underwriter.Cash += underwriting.Escrow
+    err = underwriter.save(stub)
+    if checkErrors(err){return err}
+
+    log.info("Released underwriting", "offerID", offerID, "underwriterID", underwriter.ID, "escrow", underwriting.Escrow)
+    return underwriting.resolve(stub, UNDERWRITING_RELEASED)
+}
+
+//deliverable - whether the seller still has the units the underwriter would take up and the underwriter can hold them
+func (object *Underwriting) deliverable(stub *shim.ChaincodeStub, offer Offer) (bool, error) {
+    if !offer.Escrowed {
+        seller, err := getAccount(stub, offer.Seller)
+        if checkErrors(err){return false, err}
+        if seller.getHolding(offer.PropertyID) < object.Taken {return false, nil}
+    }
+
+    underwriter, err := getAccount(stub, object.UnderwriterID)
+    if checkErrors(err){return false, err}
+    err = checkHoldingLimit(stub, underwriter, offer.PropertyID, object.Taken)
+    if errorCode(err) == ERR_STATE_VIOLATION {return false, nil}
+    if checkErrors(err){return false, err}
+    return true, nil
+}
+
+func underwritingID(offerID string) string {
+    return getMd5Hash("underwriting" + offerID)
+}
+
+func (object *Underwriting) resolve(stub *shim.ChaincodeStub, status int) error {
+    var err error
+    object.Status = status
+    object.Resolved, err = getTxTime(stub)
+    if checkErrors(err){return err}
+
+    return underwritings.save(stub, object)
+}
+
+//==============================================================================================================================
+//     CRUD Subroutines
+//==============================================================================================================================
+func getUnderwriting(stub *shim.ChaincodeStub, id string) (Underwriting, error) {
+    var object Underwriting
+    err := underwritings.get(stub, id, &object)
+    return object, err
+}
+
+func (object *Underwriting) getID() string {return object.ID}
+func (object *Underwriting) getVersion() int {return object.Version}
+func (object *Underwriting) setVersion(version int) {object.Version = version}
+
+func (object *Underwriting) indexes() map[string][]string {
+    return map[string][]string{"underwriter": {indexValue(object.UnderwriterID)}}
+}
+
+//==============================================================================================================================
+//     Parsing Subroutines
+//==============================================================================================================================
+func (object *Underwriting) marshal() ([]byte, error) {
+    bytes, err := json.Marshal(object)
+    if checkErrors(err){return nil, wrapError(ERR_INTERNAL, "Error marshalling underwriting", err)}
+    return bytes, nil
+}